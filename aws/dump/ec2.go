@@ -1,12 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/fatih/structs"
+	"github.com/hamstah/awstools/common"
 )
 
 var (
@@ -58,11 +58,12 @@ func EC2ListSecurityGroups(session *Session) *ReportResult {
 			for _, securityGroup := range page.SecurityGroups {
 				resource := Resource{
 					ID: *securityGroup.GroupId,
-					ARN: fmt.Sprintf("arn:aws:ec2:%s:%s:security-group/%s",
+					ARN: common.EC2ARN(
 						*session.Config.Region,
 						*securityGroup.OwnerId,
+						"security-group",
 						*securityGroup.GroupId,
-					),
+					).String(),
 					Service:   "ec2",
 					Type:      "security-group",
 					AccountID: *securityGroup.OwnerId,
@@ -18,12 +18,29 @@ func TestParseArn(t *testing.T) {
 		[]string{"arn:partition:service:region:account-id:resourcetype:resource", "{partition service region account-id resourcetype resource }"},
 		[]string{"arn:partition:service:region:account-id:resourcetype/resource:qualifier", "{partition service region account-id resourcetype resource qualifier}"},
 		[]string{"arn:partition:service:region:account-id:resourcetype:resource:qualifier", "{partition service region account-id resourcetype resource qualifier}"},
+		[]string{"arn:partition:service:region:account-id:resourcetype/resource/qualifier/with/slashes", "{partition service region account-id resourcetype resource qualifier/with/slashes}"},
+		[]string{"arn:partition:service:region:account-id:resourcetype:resource:qualifier:with:colons", "{partition service region account-id resourcetype resource qualifier:with:colons}"},
+		[]string{"arn:aws:s3:::bucket/path/to/key", "{aws s3    bucket path/to/key}"},
+		[]string{"arn:aws:lambda:eu-west-1:123456789012:function:ping:prod", "{aws lambda eu-west-1 123456789012 function ping prod}"},
+		[]string{"arn:aws:logs:eu-west-1:123456789012:log-group:/aws/lambda/ping:*", "{aws logs eu-west-1 123456789012 log-group /aws/lambda/ping *}"},
+		[]string{"arn:aws:kms:eu-west-1:123456789012:alias/aws/ebs", "{aws kms eu-west-1 123456789012 alias aws/ebs }"},
+		[]string{"arn:aws:execute-api:eu-west-1:123456789012:a123456789/prod/GET/pets", "{aws execute-api eu-west-1 123456789012  a123456789 prod/GET/pets}"},
+		[]string{"arn:aws:iam::123456789012:user/nico", "{aws iam  123456789012 user nico }"},
 	}
 	for _, testCase := range testCases {
 		parsed, err := common.ParseARN(testCase[0])
 		require.NoError(t, err)
 		require.NotNil(t, parsed)
-		require.Equal(t, testCase[1], fmt.Sprintf("%s", *parsed))
+		require.Equal(t, testCase[1], fmt.Sprintf("{%s %s %s %s %s %s %s}",
+			parsed.Partition,
+			parsed.Service,
+			parsed.Region,
+			parsed.AccountID,
+			parsed.ResourceType,
+			parsed.Resource,
+			parsed.Qualifier,
+		))
+		require.Equal(t, testCase[0], parsed.String())
 	}
 
 }
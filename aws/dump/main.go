@@ -17,12 +17,14 @@ var (
 	output                 = kingpin.Flag("output", "Filename to store the results in.").Short('o').Required().String()
 	onlyUnmanaged          = kingpin.Flag("only-unmanaged", "Only return resources not managed by terraform.").Default("false").Bool()
 	reports                = kingpin.Flag("report", "Only run the specified report. Can be repeated.").Strings()
+	arnFilters             = kingpin.Flag("filter-arn", "Only return resources with an ARN matching the pattern, supports * and ? wildcards. Can be repeated.").Strings()
 )
 
 func main() {
 	kingpin.CommandLine.Name = "aws-dump"
 	kingpin.CommandLine.Help = "Dump AWS resources"
 	common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	accounts, err := NewAccounts(*accountsConfig)
 	common.FatalOnError(err)
@@ -71,6 +73,23 @@ func main() {
 
 	resources := Run(jobs)
 
+	if len(*arnFilters) > 0 {
+		patterns := []*common.ARNPattern{}
+		for _, filter := range *arnFilters {
+			pattern, err := common.NewARNPattern(filter)
+			common.FatalOnError(err)
+			patterns = append(patterns, pattern)
+		}
+
+		filtered := []Resource{}
+		for _, resource := range resources {
+			if common.MatchARN(resource.ARN, patterns) {
+				filtered = append(filtered, resource)
+			}
+		}
+		resources = filtered
+	}
+
 	report := []Resource{}
 	if *terraformBackendConfig != "" {
 		backends, err := NewTerraformBackends(*terraformBackendConfig)
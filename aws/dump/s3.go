@@ -1,10 +1,9 @@
 package main
 
 import (
-	"fmt"
-
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/fatih/structs"
+	"github.com/hamstah/awstools/common"
 )
 
 var (
@@ -29,7 +28,7 @@ func S3ListBuckets(session *Session) *ReportResult {
 	for _, bucket := range res.Buckets {
 		buckets = append(buckets, Resource{
 			ID:        *bucket.Name,
-			ARN:       fmt.Sprintf("arn:aws:s3:::%s", *bucket.Name),
+			ARN:       common.S3BucketARN(*session.Config.Region, *bucket.Name).String(),
 			AccountID: session.AccountID,
 			Service:   "s3",
 			Type:      "bucket",
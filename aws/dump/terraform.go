@@ -64,7 +64,7 @@ func (s3Backend *S3Backend) Download(destination string, options *Options) (map[
 		}
 
 		filename := filepath.Join(destination, s3Backend.Bucket, dir, transformed)
-		filenames[filename] = fmt.Sprintf("arn:aws:s3:::%s/%s", s3Backend.Bucket, key)
+		filenames[filename] = common.S3ObjectARN(*conf.Region, s3Backend.Bucket, key).String()
 
 		if _, err := os.Stat(filename); !os.IsNotExist(err) && !options.Overwrite {
 			// file already exists
@@ -18,6 +18,7 @@ func main() {
 	kingpin.CommandLine.Name = "cloudwatch-put-metric-data"
 	kingpin.CommandLine.Help = "Put a cloudwatch metric value."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
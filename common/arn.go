@@ -1,8 +1,11 @@
 package common
 
 import (
-	"errors"
+	"fmt"
+	"regexp"
 	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 )
 
 /*
@@ -13,6 +16,9 @@ arn:partition:service:region:account-id:resourcetype/resource/qualifier
 arn:partition:service:region:account-id:resourcetype:resource
 arn:partition:service:region:account-id:resourcetype/resource:qualifier
 arn:partition:service:region:account-id:resourcetype:resource:qualifier
+
+The qualifier keeps everything after the resource, including separators.
+Some services use their own layout, see arnResourceParsers.
 */
 
 type ARN struct {
@@ -21,14 +27,53 @@ type ARN struct {
 	Region       string
 	AccountID    string
 	ResourceType string
-	Resource     string
-	Qualifier    string
+	// Path is the hierarchy between the resource type and the resource,
+	// for example IAM paths and SSM parameter paths, without leading or
+	// trailing "/".
+	Path      string
+	Resource  string
+	Qualifier string
+
+	// ResourceTypeSeparator and QualifierSeparator are "/" or ":", "/" is used when empty.
+	ResourceTypeSeparator string
+	QualifierSeparator    string
 }
 
+var (
+	// Partitions are the known AWS partitions.
+	Partitions = []string{"aws", "aws-cn", "aws-us-gov", "aws-iso", "aws-iso-b"}
+
+	// resourceTypeSeparators is the separator used by each service between the
+	// resource type and the resource.
+	resourceTypeSeparators = map[string]string{
+		"lambda":         ":",
+		"logs":           ":",
+		"rds":            ":",
+		"secretsmanager": ":",
+	}
+
+	// arnResourceParsers parse the resource part of services which don't
+	// follow the generic layout.
+	arnResourceParsers = map[string]func(*ARN, string){
+		"apigateway":  parseARNOpaqueResource,
+		"execute-api": parseARNNamedResource,
+		"iam":         parseARNPathResource,
+		"kms":         parseARNTypedOpaqueResource,
+		"s3":          parseARNNamedResource,
+		"ssm":         parseARNPathResource,
+	}
+
+	accountIDRegexp = regexp.MustCompile(`^([0-9]{12}|aws)$`)
+	regionRegexp    = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+)
+
 func ParseARN(arn string) (*ARN, error) {
-	parts := strings.Split(arn, ":")
+	parts := strings.SplitN(arn, ":", 6)
 	if len(parts) < 6 {
-		return nil, errors.New("Invalid format")
+		return nil, fmt.Errorf("Invalid ARN %q: expected at least 6 parts separated by ':', found %d", arn, len(parts))
+	}
+	if parts[0] != "arn" {
+		return nil, fmt.Errorf("Invalid ARN %q: should start with 'arn:'", arn)
 	}
 
 	result := &ARN{
@@ -38,44 +83,305 @@ func ParseARN(arn string) (*ARN, error) {
 		AccountID: parts[4],
 	}
 
-	if len(parts) == 6 {
-		/*
-		   arn:partition:service:region:account-id:resource
-		   arn:partition:service:region:account-id:resourcetype/resource
-		   arn:partition:service:region:account-id:resourcetype/resource/qualifier
-		*/
+	parser, ok := arnResourceParsers[result.Service]
+	if !ok {
+		parser = parseARNResource
+	}
+	parser(result, parts[5])
 
-		resourceParts := strings.Split(parts[5], "/")
+	return result, nil
+}
 
-		if len(resourceParts) == 1 {
-			result.Resource = resourceParts[0]
-			return result, nil
-		}
+// cutAny splits s around the first of the separators, found is false when
+// none of them are in s.
+func cutAny(s, separators string) (before, separator, after string, found bool) {
+	i := strings.IndexAny(s, separators)
+	if i < 0 {
+		return s, "", "", false
+	}
+	return s[:i], s[i : i+1], s[i+1:], true
+}
+
+// parseARNResource handles the generic layouts listed at the top of the file.
+func parseARNResource(a *ARN, resource string) {
+	resourceType, separator, rest, found := cutAny(resource, "/:")
+	if !found {
+		a.Resource = resource
+		return
+	}
+	a.ResourceType = resourceType
+	a.ResourceTypeSeparator = separator
+
+	// with a ':' after the type the resource can contain '/', like log groups
+	qualifierSeparators := "/:"
+	if separator == ":" {
+		qualifierSeparators = ":"
+	}
 
-		result.ResourceType = resourceParts[0]
-		result.Resource = resourceParts[1]
+	a.Resource, a.QualifierSeparator, a.Qualifier, _ = cutAny(rest, qualifierSeparators)
+}
+
+// parseARNPathResource handles resourcetype/path/to/resource, like IAM roles and SSM parameters.
+func parseARNPathResource(a *ARN, resource string) {
+	resourceType, separator, rest, found := cutAny(resource, "/")
+	if !found {
+		a.Resource = resource
+		return
+	}
+	a.ResourceType = resourceType
+	a.ResourceTypeSeparator = separator
+
+	if i := strings.LastIndex(rest, "/"); i >= 0 {
+		a.Path = rest[:i]
+		rest = rest[i+1:]
+	}
+	a.Resource = rest
+}
 
-		if len(resourceParts) > 2 {
-			result.Qualifier = resourceParts[2]
+// parseARNNamedResource handles resource[/qualifier], like S3 bucket/key or
+// API gateway api-id/stage/method/path.
+func parseARNNamedResource(a *ARN, resource string) {
+	a.Resource, a.QualifierSeparator, a.Qualifier, _ = cutAny(resource, "/")
+}
+
+// parseARNTypedOpaqueResource handles resourcetype/resource where the resource can contain '/', like KMS aliases.
+func parseARNTypedOpaqueResource(a *ARN, resource string) {
+	resourceType, separator, rest, found := cutAny(resource, "/:")
+	if !found {
+		a.Resource = resource
+		return
+	}
+	a.ResourceType = resourceType
+	a.ResourceTypeSeparator = separator
+	a.Resource = rest
+}
+
+// parseARNOpaqueResource keeps the resource as is, like API gateway paths.
+func parseARNOpaqueResource(a *ARN, resource string) {
+	a.Resource = resource
+}
+
+func separatorOrDefault(separator string) string {
+	if separator == "" {
+		return "/"
+	}
+	return separator
+}
+
+// String returns the ARN in its canonical form, ParseARN(arn).String() == arn.
+func (a *ARN) String() string {
+	resource := a.Resource
+	if a.Path != "" {
+		resource = a.Path + "/" + resource
+	}
+	if a.ResourceType != "" {
+		resource = a.ResourceType + separatorOrDefault(a.ResourceTypeSeparator) + resource
+	}
+	if a.Qualifier != "" {
+		resource = resource + separatorOrDefault(a.QualifierSeparator) + a.Qualifier
+	}
+	return strings.Join([]string{"arn", a.Partition, a.Service, a.Region, a.AccountID, resource}, ":")
+}
+
+// S3Bucket returns the bucket of S3 ARNs.
+func (a *ARN) S3Bucket() string {
+	return a.Resource
+}
+
+// S3Key returns the object key of S3 ARNs, empty for buckets.
+func (a *ARN) S3Key() string {
+	return a.Qualifier
+}
+
+// IAMPath returns the path of IAM ARNs in the format used by the IAM API, "/" when empty.
+func (a *ARN) IAMPath() string {
+	if a.Path == "" {
+		return "/"
+	}
+	return "/" + a.Path + "/"
+}
+
+// SSMParameterName returns the full name of SSM parameter ARNs.
+func (a *ARN) SSMParameterName() string {
+	if a.Path == "" {
+		return a.Resource
+	}
+	return "/" + a.Path + "/" + a.Resource
+}
+
+// LogGroupName returns the log group of CloudWatch logs ARNs.
+func (a *ARN) LogGroupName() string {
+	return a.Resource
+}
+
+// LogStreamName returns the log stream of CloudWatch logs ARNs, empty if not a stream.
+func (a *ARN) LogStreamName() string {
+	if strings.HasPrefix(a.Qualifier, "log-stream:") {
+		return a.Qualifier[len("log-stream:"):]
+	}
+	return ""
+}
+
+// Validate checks the ARN components and returns a descriptive error for the first invalid one.
+func (a *ARN) Validate() error {
+	if !containsString(Partitions, a.Partition) {
+		return fmt.Errorf("Invalid ARN %s: unknown partition %q, expected one of %s", a, a.Partition, strings.Join(Partitions, ", "))
+	}
+	if a.Service == "" {
+		return fmt.Errorf("Invalid ARN %s: service is empty", a)
+	}
+	if a.Region != "" && !regionRegexp.MatchString(a.Region) {
+		return fmt.Errorf("Invalid ARN %s: invalid region %q", a, a.Region)
+	}
+	if a.AccountID != "" && !accountIDRegexp.MatchString(a.AccountID) {
+		return fmt.Errorf("Invalid ARN %s: account ID %q should be 12 digits", a, a.AccountID)
+	}
+	if a.Resource == "" {
+		return fmt.Errorf("Invalid ARN %s: resource is empty", a)
+	}
+	for _, separator := range []string{a.ResourceTypeSeparator, a.QualifierSeparator} {
+		if separator != "" && separator != "/" && separator != ":" {
+			return fmt.Errorf("Invalid ARN %s: invalid separator %q", a, separator)
 		}
-		return result, nil
 	}
+	return nil
+}
 
-	if len(parts) == 8 {
-		result.ResourceType = parts[5]
-		result.Resource = parts[6]
-		result.Qualifier = parts[7]
-		return result, nil
+// ValidateARN parses and validates an ARN.
+func ValidateARN(arn string) error {
+	parsed, err := ParseARN(arn)
+	if err != nil {
+		return err
 	}
+	return parsed.Validate()
+}
 
-	resourceParts := strings.Split(parts[5], "/")
-	result.ResourceType = resourceParts[0]
-	if len(resourceParts) == 1 {
-		result.Resource = parts[6]
-		return result, nil
+// PartitionForRegion returns the partition of a region, aws if unknown.
+func PartitionForRegion(region string) string {
+	if partition, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		return partition.ID()
 	}
-	result.Resource = resourceParts[1]
-	result.Qualifier = parts[6]
 
-	return result, nil
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	}
+	return "aws"
+}
+
+// NewARN builds the ARN of a resource, the partition is derived from the region.
+// Global services have an empty region, use WithPartitionOf to set their partition.
+func NewARN(service, region, accountID, resourceType, resource string) *ARN {
+	separator := ""
+	if resourceType != "" {
+		separator = separatorOrDefault(resourceTypeSeparators[service])
+	}
+
+	return &ARN{
+		Partition:             PartitionForRegion(region),
+		Service:               service,
+		Region:                region,
+		AccountID:             accountID,
+		ResourceType:          resourceType,
+		Resource:              resource,
+		ResourceTypeSeparator: separator,
+	}
+}
+
+// WithPartitionOf sets the partition from a region, used for the ARNs of global services.
+func (a *ARN) WithPartitionOf(region string) *ARN {
+	a.Partition = PartitionForRegion(region)
+	return a
+}
+
+func S3BucketARN(region, bucket string) *ARN {
+	return NewARN("s3", "", "", "", bucket).WithPartitionOf(region)
+}
+
+func S3ObjectARN(region, bucket, key string) *ARN {
+	arn := S3BucketARN(region, bucket)
+	arn.Qualifier = key
+	arn.QualifierSeparator = "/"
+	return arn
+}
+
+func IAMARN(region, accountID, resourceType, name string) *ARN {
+	return NewARN("iam", "", accountID, resourceType, name).WithPartitionOf(region)
+}
+
+func EC2ARN(region, accountID, resourceType, id string) *ARN {
+	return NewARN("ec2", region, accountID, resourceType, id)
+}
+
+func LambdaFunctionARN(region, accountID, name string) *ARN {
+	return NewARN("lambda", region, accountID, "function", name)
+}
+
+func KMSKeyARN(region, accountID, keyID string) *ARN {
+	return NewARN("kms", region, accountID, "key", keyID)
+}
+
+func SSMParameterARN(region, accountID, name string) *ARN {
+	arn := NewARN("ssm", region, accountID, "parameter", strings.Trim(name, "/"))
+	if i := strings.LastIndex(arn.Resource, "/"); i >= 0 {
+		arn.Path = arn.Resource[:i]
+		arn.Resource = arn.Resource[i+1:]
+	}
+	return arn
+}
+
+// ARNPattern matches ARNs against a pattern using IAM wildcards, * matches
+// any sequence of characters and ? any single character within a component.
+type ARNPattern struct {
+	Pattern string
+	parts   []*regexp.Regexp
+}
+
+func NewARNPattern(pattern string) (*ARNPattern, error) {
+	parts := strings.SplitN(pattern, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return nil, fmt.Errorf("Invalid ARN pattern %q: should have the form arn:partition:service:region:account-id:resource", pattern)
+	}
+
+	res := &ARNPattern{Pattern: pattern}
+	for _, part := range parts {
+		expr := regexp.QuoteMeta(part)
+		expr = strings.Replace(expr, `\*`, ".*", -1)
+		expr = strings.Replace(expr, `\?`, ".", -1)
+		compiled, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, err
+		}
+		res.parts = append(res.parts, compiled)
+	}
+	return res, nil
+}
+
+func (p *ARNPattern) Match(arn string) bool {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return false
+	}
+	for i, part := range parts {
+		if !p.parts[i].MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchARN returns true if arn matches any of the patterns.
+func MatchARN(arn string, patterns []*ARNPattern) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(arn) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,200 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseARNServices(t *testing.T) {
+	testCases := []struct {
+		arn          string
+		resourceType string
+		path         string
+		resource     string
+		qualifier    string
+	}{
+		// generic
+		{"arn:aws:sns:eu-west-1:123456789012:topic-name", "", "", "topic-name", ""},
+		{"arn:aws:sqs:eu-west-1:123456789012:queue-name", "", "", "queue-name", ""},
+		{"arn:aws:ec2:eu-west-1:123456789012:security-group/sg-123", "security-group", "", "sg-123", ""},
+		{"arn:aws:ec2:eu-west-1:123456789012:instance/i-123", "instance", "", "i-123", ""},
+		{"arn:aws:ecs:eu-west-1:123456789012:cluster/main", "cluster", "", "main", ""},
+		{"arn:aws:ecs:eu-west-1:123456789012:service/main/web", "service", "", "main", "web"},
+		{"arn:aws:ecs:eu-west-1:123456789012:task/main/0123456789abcdef", "task", "", "main", "0123456789abcdef"},
+		{"arn:aws:ecs:eu-west-1:123456789012:task-definition/web:12", "task-definition", "", "web", "12"},
+		{"arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/my-alb/50dc6c495c0c9188", "loadbalancer", "", "app", "my-alb/50dc6c495c0c9188"},
+		{"arn:aws:elasticloadbalancing:eu-west-1:123456789012:targetgroup/my-targets/73e2d6bc24d8a067", "targetgroup", "", "my-targets", "73e2d6bc24d8a067"},
+		{"arn:aws:dynamodb:eu-west-1:123456789012:table/books/stream/2015-05-11T21:21:33.291", "table", "", "books", "stream/2015-05-11T21:21:33.291"},
+		{"arn:aws:acm:eu-west-1:123456789012:certificate/12345678-1234-1234-1234-123456789012", "certificate", "", "12345678-1234-1234-1234-123456789012", ""},
+		{"arn:aws:route53:::hostedzone/Z123", "hostedzone", "", "Z123", ""},
+		{"arn:aws:sts::123456789012:assumed-role/my-role/session-name", "assumed-role", "", "my-role", "session-name"},
+		{"arn:aws:cloudwatch:eu-west-1:123456789012:alarm:my-alarm", "alarm", "", "my-alarm", ""},
+		{"arn:aws:rds:eu-west-1:123456789012:db:my-db", "db", "", "my-db", ""},
+
+		// lambda
+		{"arn:aws:lambda:eu-west-1:123456789012:function:ping", "function", "", "ping", ""},
+		{"arn:aws:lambda:eu-west-1:123456789012:function:ping:prod", "function", "", "ping", "prod"},
+		{"arn:aws:lambda:eu-west-1:123456789012:function:ping:$LATEST", "function", "", "ping", "$LATEST"},
+		{"arn:aws:lambda:eu-west-1:123456789012:layer:common:3", "layer", "", "common", "3"},
+		{"arn:aws:lambda:eu-west-1:123456789012:event-source-mapping:fa4a5f1e-0e8a-4b35-9b62-9d8e6f1c5e4d", "event-source-mapping", "", "fa4a5f1e-0e8a-4b35-9b62-9d8e6f1c5e4d", ""},
+
+		// cloudwatch logs
+		{"arn:aws:logs:eu-west-1:123456789012:log-group:my-group", "log-group", "", "my-group", ""},
+		{"arn:aws:logs:eu-west-1:123456789012:log-group:/aws/lambda/ping:*", "log-group", "", "/aws/lambda/ping", "*"},
+		{"arn:aws:logs:eu-west-1:123456789012:log-group:/ecs/web:log-stream:web/web/0123:456", "log-group", "", "/ecs/web", "log-stream:web/web/0123:456"},
+
+		// secrets manager
+		{"arn:aws:secretsmanager:eu-west-1:123456789012:secret:hamstah/awstools/tests/test-1-AbCdEf", "secret", "", "hamstah/awstools/tests/test-1-AbCdEf", ""},
+
+		// s3
+		{"arn:aws:s3:::bucket", "", "", "bucket", ""},
+		{"arn:aws:s3:::bucket/key", "", "", "bucket", "key"},
+		{"arn:aws:s3:::bucket/path/to/key", "", "", "bucket", "path/to/key"},
+		{"arn:aws:s3:::bucket/path/with:colon/key", "", "", "bucket", "path/with:colon/key"},
+		{"arn:aws:s3:::bucket/*", "", "", "bucket", "*"},
+
+		// iam
+		{"arn:aws:iam::123456789012:root", "", "", "root", ""},
+		{"arn:aws:iam::123456789012:user/nico", "user", "", "nico", ""},
+		{"arn:aws:iam::123456789012:user/division/team/nico", "user", "division/team", "nico", ""},
+		{"arn:aws:iam::123456789012:role/service-role/my-role", "role", "service-role", "my-role", ""},
+		{"arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole", "policy", "service-role", "AWSLambdaBasicExecutionRole", ""},
+		{"arn:aws:iam::123456789012:mfa/nico", "mfa", "", "nico", ""},
+
+		// ssm
+		{"arn:aws:ssm:eu-west-1:123456789012:parameter/key", "parameter", "", "key", ""},
+		{"arn:aws:ssm:eu-west-1:123456789012:parameter/hamstah/awstools/tests/test-1/key-1", "parameter", "hamstah/awstools/tests/test-1", "key-1", ""},
+
+		// kms
+		{"arn:aws:kms:eu-west-1:123456789012:key/41694ce4-3596-455c-89ae-b36f9e20566a", "key", "", "41694ce4-3596-455c-89ae-b36f9e20566a", ""},
+		{"arn:aws:kms:eu-west-1:123456789012:alias/aws/ebs", "alias", "", "aws/ebs", ""},
+
+		// api gateway
+		{"arn:aws:apigateway:eu-west-1::/restapis/a123456789/stages/prod", "", "", "/restapis/a123456789/stages/prod", ""},
+		{"arn:aws:execute-api:eu-west-1:123456789012:a123456789/prod/GET/pets/{petId}", "", "", "a123456789", "prod/GET/pets/{petId}"},
+		{"arn:aws:execute-api:eu-west-1:123456789012:a123456789/*/POST/*", "", "", "a123456789", "*/POST/*"},
+
+		// other partitions
+		{"arn:aws-cn:s3:::bucket/key", "", "", "bucket", "key"},
+		{"arn:aws-us-gov:iam::123456789012:role/path/role", "role", "path", "role", ""},
+	}
+
+	for _, testCase := range testCases {
+		parsed, err := ParseARN(testCase.arn)
+		require.NoError(t, err, testCase.arn)
+		assert.Equal(t, testCase.resourceType, parsed.ResourceType, testCase.arn)
+		assert.Equal(t, testCase.path, parsed.Path, testCase.arn)
+		assert.Equal(t, testCase.resource, parsed.Resource, testCase.arn)
+		assert.Equal(t, testCase.qualifier, parsed.Qualifier, testCase.arn)
+		assert.Equal(t, testCase.arn, parsed.String())
+	}
+}
+
+func TestARNServiceFields(t *testing.T) {
+	parsed, err := ParseARN("arn:aws:s3:::bucket/path/to/key")
+	require.NoError(t, err)
+	assert.Equal(t, "bucket", parsed.S3Bucket())
+	assert.Equal(t, "path/to/key", parsed.S3Key())
+
+	parsed, err = ParseARN("arn:aws:iam::123456789012:user/division/team/nico")
+	require.NoError(t, err)
+	assert.Equal(t, "/division/team/", parsed.IAMPath())
+	assert.Equal(t, "nico", parsed.Resource)
+
+	parsed, err = ParseARN("arn:aws:iam::123456789012:user/nico")
+	require.NoError(t, err)
+	assert.Equal(t, "/", parsed.IAMPath())
+
+	parsed, err = ParseARN("arn:aws:ssm:eu-west-1:123456789012:parameter/a/b/c")
+	require.NoError(t, err)
+	assert.Equal(t, "/a/b/c", parsed.SSMParameterName())
+	assert.Equal(t, parsed, SSMParameterARN("eu-west-1", "123456789012", "/a/b/c"))
+
+	parsed, err = ParseARN("arn:aws:logs:eu-west-1:123456789012:log-group:/ecs/web:log-stream:web/web/0123")
+	require.NoError(t, err)
+	assert.Equal(t, "/ecs/web", parsed.LogGroupName())
+	assert.Equal(t, "web/web/0123", parsed.LogStreamName())
+
+	parsed, err = ParseARN("arn:aws:s3:::bucket/key")
+	require.NoError(t, err)
+	assert.Equal(t, parsed, S3ObjectARN("eu-west-1", "bucket", "key"))
+}
+
+func TestParseARNInvalid(t *testing.T) {
+	for _, arn := range []string{"", "arn:aws:s3", "foo:aws:iam::123456789012:role/test"} {
+		_, err := ParseARN(arn)
+		assert.Error(t, err, arn)
+	}
+}
+
+func TestValidateARN(t *testing.T) {
+	valid := []string{
+		"arn:aws:iam::123456789012:role/test",
+		"arn:aws:iam::aws:policy/AdministratorAccess",
+		"arn:aws-cn:s3:::bucket",
+		"arn:aws-us-gov:ec2:us-gov-west-1:123456789012:security-group/sg-123",
+	}
+	for _, arn := range valid {
+		assert.NoError(t, ValidateARN(arn), arn)
+	}
+
+	invalid := []string{
+		"arn:foo:iam::123456789012:role/test",
+		"arn:aws::eu-west-1:123456789012:role/test",
+		"arn:aws:ec2:eu-west:123456789012:security-group/sg-123",
+		"arn:aws:iam::1234:role/test",
+		"arn:aws:s3:::",
+	}
+	for _, arn := range invalid {
+		assert.Error(t, ValidateARN(arn), arn)
+	}
+}
+
+func TestPartitionForRegion(t *testing.T) {
+	assert.Equal(t, "aws", PartitionForRegion("eu-west-1"))
+	assert.Equal(t, "aws-cn", PartitionForRegion("cn-north-1"))
+	assert.Equal(t, "aws-us-gov", PartitionForRegion("us-gov-west-1"))
+	assert.Equal(t, "aws", PartitionForRegion(""))
+}
+
+func TestARNBuilders(t *testing.T) {
+	assert.Equal(t, "arn:aws:s3:::bucket", S3BucketARN("eu-west-1", "bucket").String())
+	assert.Equal(t, "arn:aws-cn:s3:::bucket/path/to/key", S3ObjectARN("cn-north-1", "bucket", "path/to/key").String())
+	assert.Equal(t, "arn:aws-us-gov:iam::123456789012:role/ops", IAMARN("us-gov-west-1", "123456789012", "role", "ops").String())
+	assert.Equal(t, "arn:aws:ec2:eu-west-1:123456789012:security-group/sg-123", EC2ARN("eu-west-1", "123456789012", "security-group", "sg-123").String())
+	assert.Equal(t, "arn:aws:lambda:eu-west-1:123456789012:function:ping", LambdaFunctionARN("eu-west-1", "123456789012", "ping").String())
+	assert.Equal(t, "arn:aws:kms:eu-west-1:123456789012:key/abc", KMSKeyARN("eu-west-1", "123456789012", "abc").String())
+	assert.Equal(t, "arn:aws:ssm:eu-west-1:123456789012:parameter/a/b", SSMParameterARN("eu-west-1", "123456789012", "/a/b").String())
+
+	for _, arn := range []*ARN{S3BucketARN("eu-west-1", "bucket"), LambdaFunctionARN("eu-west-1", "123456789012", "ping")} {
+		assert.NoError(t, arn.Validate())
+	}
+}
+
+func TestARNPattern(t *testing.T) {
+	pattern, err := NewARNPattern("arn:aws:iam::*:role/ops-*")
+	require.NoError(t, err)
+
+	assert.True(t, pattern.Match("arn:aws:iam::123456789012:role/ops-admin"))
+	assert.True(t, pattern.Match("arn:aws:iam::210987654321:role/ops-path/nested"))
+	assert.False(t, pattern.Match("arn:aws:iam::123456789012:role/dev-admin"))
+	assert.False(t, pattern.Match("arn:aws-cn:iam::123456789012:role/ops-admin"))
+	assert.False(t, pattern.Match("not-an-arn"))
+
+	pattern, err = NewARNPattern("arn:aws:ec2:eu-west-?:*:security-group/*")
+	require.NoError(t, err)
+	assert.True(t, pattern.Match("arn:aws:ec2:eu-west-1:123456789012:security-group/sg-123"))
+	assert.False(t, pattern.Match("arn:aws:ec2:eu-central-1:123456789012:security-group/sg-123"))
+	assert.True(t, MatchARN("arn:aws:s3:::bucket", []*ARNPattern{pattern, mustARNPattern(t, "arn:aws:s3:::*")}))
+
+	_, err = NewARNPattern("arn:aws:iam")
+	assert.Error(t, err)
+}
+
+func mustARNPattern(t *testing.T, pattern string) *ARNPattern {
+	res, err := NewARNPattern(pattern)
+	require.NoError(t, err)
+	return res
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SourceVersioner is implemented by resolvers which can look up the current
+// version of a source without fetching its value. An empty version means the
+// source is not versioned and is fetched again when its cache entry expires.
+type SourceVersioner interface {
+	Version(source Source, state *RefreshState) (string, error)
+}
+
+type sourceCacheEntry struct {
+	Value     interface{}
+	Version   string
+	FetchedAt time.Time
+}
+
+// SourceCache keeps resolved source values for TTL. Expired values are only
+// fetched again when their version changed, for resolvers implementing SourceVersioner.
+type SourceCache struct {
+	TTL     time.Duration
+	entries map[string]*sourceCacheEntry
+	m       sync.Mutex
+}
+
+func NewSourceCache(ttl time.Duration) *SourceCache {
+	return &SourceCache{
+		TTL:     ttl,
+		entries: map[string]*sourceCacheEntry{},
+	}
+}
+
+func sourceCacheKey(source Source) string {
+	return fmt.Sprintf("%s:%s", source.Type, source.Identifier)
+}
+
+func (c *SourceCache) get(key string) *sourceCacheEntry {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.entries[key]
+}
+
+func (c *SourceCache) set(key string, entry *sourceCacheEntry) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.entries[key] = entry
+}
+
+func (c *SourceCache) fresh(source Source) bool {
+	entry := c.get(sourceCacheKey(source))
+	return entry != nil && time.Since(entry.FetchedAt) < c.TTL
+}
+
+// Clear removes all the cached values.
+func (c *SourceCache) Clear() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.entries = map[string]*sourceCacheEntry{}
+}
+
+// Resolve returns the cached value of the source or resolves it.
+func (c *SourceCache) Resolve(resolver SourceResolver, source Source, state *RefreshState) (interface{}, error) {
+	key := sourceCacheKey(source)
+	entry := c.get(key)
+	now := time.Now()
+
+	if entry != nil && now.Sub(entry.FetchedAt) < c.TTL {
+		return entry.Value, nil
+	}
+
+	version := ""
+	if versioner, ok := resolver.(SourceVersioner); ok {
+		var err error
+		version, err = versioner.Version(source, state)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && version != "" && version == entry.Version {
+			c.set(key, &sourceCacheEntry{Value: entry.Value, Version: version, FetchedAt: now})
+			return entry.Value, nil
+		}
+	}
+
+	value, err := resolver.Resolve(source, state)
+	if err != nil {
+		return nil, err
+	}
+	c.set(key, &sourceCacheEntry{Value: value, Version: version, FetchedAt: now})
+	return value, nil
+}
+
+// funcResolver adds a SourceVersioner and a SourceValidator to a SourceResolverFunc.
+type funcResolver struct {
+	SourceResolverFunc
+	version  func(source Source, state *RefreshState) (string, error)
+	validate func(source Source, state *RefreshState) error
+}
+
+func (r funcResolver) Version(source Source, state *RefreshState) (string, error) {
+	return r.version(source, state)
+}
+
+func (r funcResolver) Validate(source Source, state *RefreshState) error {
+	return r.validate(source, state)
+}
+
+func versionFile(source Source, state *RefreshState) (string, error) {
+	info, err := os.Stat(source.Identifier)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// versionKMS uses the ciphertext as version, it never changes.
+func versionKMS(source Source, state *RefreshState) (string, error) {
+	return source.Identifier, nil
+}
+
+func versionS3(source Source, state *RefreshState) (string, error) {
+	object, err := parseS3Identifier(source.Identifier)
+	if err != nil {
+		return "", err
+	}
+	if object.VersionID != "" {
+		return object.VersionID, nil
+	}
+	if state.S3Client == nil {
+		state.S3Client = s3.New(state.Session, state.Config)
+	}
+	res, err := state.S3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Key),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(res.ETag), nil
+}
@@ -0,0 +1,155 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var environmentKeyReplacer = strings.NewReplacer("-", "_", ".", "_", "/", "_")
+
+// EnvironmentKey returns the environment variable for the field key of an
+// object loaded as prefix. key is upper cased with - . and / replaced by _.
+func EnvironmentKey(prefix, key string) string {
+	key = strings.ToUpper(environmentKeyReplacer.Replace(key))
+	if prefix == "" {
+		return key
+	}
+	return prefix + "_" + key
+}
+
+func ConvertMap(source map[string]string, prefix string) map[string]string {
+	res := make(map[string]string, len(source))
+	for key, value := range source {
+		res[EnvironmentKey(prefix, key)] = value
+	}
+	return res
+}
+
+// SetFromEnvironment sets the config from KEY=VALUE variables. A variable with
+// a key prefix is a source named after the variable without the prefix, or
+// without a name if the rest starts with _. A variable with a value prefix is
+// a source named after the variable. Other variables are static, templates
+// are not parsed as ${ is common in environment values.
+func (c *ConfigValues) SetFromEnvironment(env []string) {
+	c.Static = map[string]interface{}{}
+
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, value := parts[0], parts[1]
+
+		sourceType, prefix := longestPrefix(c.KeyPrefixes, key)
+		if sourceType != "" {
+			name := key[len(prefix):]
+			if strings.HasPrefix(name, "_") {
+				name = ""
+			}
+			c.Static[key] = Source{Type: sourceType, Name: name, Identifier: value}
+			continue
+		}
+
+		sourceType, prefix = longestPrefix(c.ValuePrefixes, value)
+		if sourceType != "" {
+			c.Static[key] = Source{Type: sourceType, Name: key, Identifier: value[len(prefix):]}
+			continue
+		}
+		c.Static[key] = value
+	}
+}
+
+// longestPrefix returns the type with the longest non empty prefix of value.
+func longestPrefix(prefixes map[string]string, value string) (string, string) {
+	sourceType, longest := "", ""
+	for t, prefix := range prefixes {
+		if prefix != "" && len(prefix) > len(longest) && strings.HasPrefix(value, prefix) {
+			sourceType, longest = t, prefix
+		}
+	}
+	return sourceType, longest
+}
+
+// Environment converts values refreshed after SetFromEnvironment to
+// environment variables. Objects are flattened with EnvironmentKey, prefixed
+// with the source name if it has one. A variable set by a source and a static
+// variable or by several sources is an error unless allowOverride is set, then
+// sources override static variables and are applied in the order of their keys.
+func (c *ConfigValues) Environment(values map[string]interface{}, allowOverride bool) (map[string]string, error) {
+	env := map[string]string{}
+	origins := map[string]string{}
+	sourceKeys := []string{}
+
+	for key, value := range values {
+		if _, ok := c.Static[key].(Source); ok {
+			sourceKeys = append(sourceKeys, key)
+			continue
+		}
+		env[key] = EnvironmentValue(value)
+		origins[key] = key
+	}
+	sort.Strings(sourceKeys)
+
+	for _, key := range sourceKeys {
+		sourceEnv := map[string]string{}
+		err := addEnvironment(sourceEnv, c.Static[key].(Source).Name, values[key])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to convert %s", key)
+		}
+
+		for name, value := range sourceEnv {
+			if origin, ok := origins[name]; ok && !allowOverride {
+				return nil, fmt.Errorf("%s is set by both %s and %s", name, origin, key)
+			}
+			env[name] = value
+			origins[name] = key
+		}
+	}
+	return env, nil
+}
+
+func addEnvironment(env map[string]string, name string, value interface{}) error {
+	if object, ok := value.(map[string]interface{}); ok {
+		for key, value := range object {
+			err := addEnvironment(env, EnvironmentKey(name, key), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if name == "" {
+		return errors.New("Values without a name should be objects")
+	}
+	env[name] = EnvironmentValue(value)
+	return nil
+}
+
+// EnvironmentValue converts a JSON value to an environment variable value,
+// strings are kept as is, numbers and booleans are formatted and other values
+// are encoded as JSON.
+func EnvironmentValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return ""
+	case json.Number:
+		return value.String()
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
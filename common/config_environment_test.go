@@ -0,0 +1,132 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvironmentKey(t *testing.T) {
+	assert.Equal(t, "FOO_BAR", EnvironmentKey("", "foo-bar"))
+	assert.Equal(t, "DB_HOST_NAME", EnvironmentKey("DB", "host.name"))
+	assert.Equal(t, "app_A_B", EnvironmentKey("app", "a/b"))
+}
+
+func TestSetFromEnvironment(t *testing.T) {
+	c := NewConfigValues()
+	c.SetFromEnvironment([]string{
+		"SSM_DB=/db/*",
+		"SSM__SHARED=/shared/*",
+		"KMS_TOKEN=blob",
+		"PASSWORD=ssm:///db/password",
+		"HOME=/root",
+		"PS1=${PWD}",
+		"INVALID",
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"SSM_DB":      Source{Type: "SSM", Name: "DB", Identifier: "/db/*"},
+		"SSM__SHARED": Source{Type: "SSM", Name: "", Identifier: "/shared/*"},
+		"KMS_TOKEN":   Source{Type: "KMS", Name: "TOKEN", Identifier: "blob"},
+		"PASSWORD":    Source{Type: "SSM", Name: "PASSWORD", Identifier: "/db/password"},
+		"HOME":        "/root",
+		"PS1":         "${PWD}",
+	}, c.Static)
+	assert.True(t, c.IsRefreshable())
+
+	c.SetFromEnvironment([]string{"HOME=/root"})
+	assert.False(t, c.IsRefreshable())
+}
+
+func TestEnvironment(t *testing.T) {
+	state, _, server := newFakeSSMState(t, map[string]string{
+		"/db/host":          "db.local",
+		"/db/port":          "5432",
+		"/db/password":      "secret",
+		"/shared/log-level": "debug",
+		"/shared/region":    "eu-west-1",
+	})
+	defer server.Close()
+
+	c := NewConfigValues()
+	c.SetFromEnvironment([]string{
+		"SSM_DB=/db/*",
+		"SSM__SHARED=/shared/*",
+		"PASSWORD=ssm:///db/password",
+		"APP=demo",
+	})
+
+	values := map[string]interface{}{}
+	require.NoError(t, c.Refresh(state.Session, state.Config, &values))
+
+	env, err := c.Environment(values, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":     "db.local",
+		"DB_PORT":     "5432",
+		"DB_PASSWORD": "secret",
+		"LOG_LEVEL":   "debug",
+		"PASSWORD":    "secret",
+		"REGION":      "eu-west-1",
+		"APP":         "demo",
+	}, env)
+}
+
+func TestEnvironmentValues(t *testing.T) {
+	c := NewConfigValues()
+	c.Static = map[string]interface{}{
+		"SECRETS_MANAGER_DB": Source{Type: "SECRETS_MANAGER", Name: "DB"},
+		"KMS__TOKEN":         Source{Type: "KMS", Name: ""},
+	}
+
+	env, err := c.Environment(map[string]interface{}{
+		"SECRETS_MANAGER_DB": map[string]interface{}{
+			"port":    float64(5432),
+			"ssl":     true,
+			"hosts":   []interface{}{"a", "b"},
+			"options": map[string]interface{}{"pool-size": "10"},
+		},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_PORT":              "5432",
+		"DB_SSL":               "true",
+		"DB_HOSTS":             `["a","b"]`,
+		"DB_OPTIONS_POOL_SIZE": "10",
+	}, env)
+
+	_, err = c.Environment(map[string]interface{}{"KMS__TOKEN": "secret"}, false)
+	assert.Error(t, err)
+}
+
+func TestEnvironmentOverride(t *testing.T) {
+	c := NewConfigValues()
+	c.SetFromEnvironment([]string{
+		"SSM_DB=/db/*",
+		"SECRETS_MANAGER__SHARED=shared",
+		"DB_HOST=static",
+	})
+	values := map[string]interface{}{
+		"SSM_DB":                  map[string]interface{}{"host": "ssm"},
+		"SECRETS_MANAGER__SHARED": map[string]interface{}{"region": "eu-west-1"},
+		"DB_HOST":                 "static",
+	}
+
+	_, err := c.Environment(values, false)
+	assert.EqualError(t, err, "DB_HOST is set by both DB_HOST and SSM_DB")
+
+	env, err := c.Environment(values, true)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"DB_HOST": "ssm", "REGION": "eu-west-1"}, env)
+
+	values["SECRETS_MANAGER__SHARED"] = map[string]interface{}{"db-host": "secrets-manager"}
+	delete(values, "DB_HOST")
+	delete(c.Static, "DB_HOST")
+	_, err = c.Environment(values, false)
+	assert.EqualError(t, err, "DB_HOST is set by both SECRETS_MANAGER__SHARED and SSM_DB")
+
+	env, err = c.Environment(values, true)
+	require.NoError(t, err)
+	assert.Equal(t, "ssm", env["DB_HOST"])
+}
@@ -0,0 +1,251 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// secretsManagerResolver resolves secrets, JSON objects and arrays are decoded
+// and other secrets are returned as strings. Identifiers are
+// name[@STAGE][?version=ID][#path]: @STAGE and ?version= pin a version, like
+// the S3 source, and #path extracts a single field of a JSON secret, for
+// example db@AWSPREVIOUS#password or db#hosts[0].
+type secretsManagerResolver struct {
+	// VersionStage defaults to AWSCURRENT.
+	VersionStage string
+}
+
+// NewSecretsManagerResolver returns a resolver fetching the versionStage
+// version of secrets without a pinned version, use it to replace the
+// SECRETS_MANAGER resolver.
+func NewSecretsManagerResolver(versionStage string) SourceResolver {
+	return secretsManagerResolver{VersionStage: versionStage}
+}
+
+var (
+	secretsManagerStageRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// Version ids are client request tokens, any string of 32 to 64 characters.
+	secretsManagerVersionRegexp = regexp.MustCompile(`^[^#?&=]{32,64}$`)
+)
+
+type secretsManagerSecret struct {
+	SecretID     string
+	VersionStage string
+	VersionID    string
+	Path         string
+}
+
+// parseSecretsManagerIdentifier splits name[@STAGE][?version=ID][#path]. Names
+// can contain @, only the part after the last @ is a stage and only if it is
+// made of letters, digits, _ and -. Names cannot contain ? or #.
+func parseSecretsManagerIdentifier(identifier string) (*secretsManagerSecret, error) {
+	parts := strings.SplitN(identifier, "#", 2)
+	secret := &secretsManagerSecret{SecretID: parts[0]}
+	if len(parts) == 2 {
+		secret.Path = parts[1]
+	}
+
+	parts = strings.SplitN(secret.SecretID, "?", 2)
+	secret.SecretID = parts[0]
+	if len(parts) == 2 {
+		query := parts[1]
+		if !strings.HasPrefix(query, "version=") {
+			return nil, fmt.Errorf("Invalid secret %s, only ?version= is supported", identifier)
+		}
+		secret.VersionID = strings.TrimPrefix(query, "version=")
+		if !secretsManagerVersionRegexp.MatchString(secret.VersionID) {
+			return nil, fmt.Errorf("Invalid secret %s, the version id must have 32 to 64 characters", identifier)
+		}
+	}
+
+	if i := strings.LastIndex(secret.SecretID, "@"); i > 0 && secretsManagerStageRegexp.MatchString(secret.SecretID[i+1:]) {
+		secret.VersionStage = secret.SecretID[i+1:]
+		secret.SecretID = secret.SecretID[:i]
+	}
+
+	if secret.VersionStage != "" && secret.VersionID != "" {
+		return nil, fmt.Errorf("Invalid secret %s, use either @STAGE or ?version=", identifier)
+	}
+	return secret, nil
+}
+
+// parse defaults the stage to the stage of the resolver when no version is pinned.
+func (r secretsManagerResolver) parse(identifier string) (*secretsManagerSecret, error) {
+	secret, err := parseSecretsManagerIdentifier(identifier)
+	if err != nil {
+		return nil, err
+	}
+	if secret.VersionStage == "" && secret.VersionID == "" {
+		secret.VersionStage = r.VersionStage
+		if secret.VersionStage == "" {
+			secret.VersionStage = "AWSCURRENT"
+		}
+	}
+	return secret, nil
+}
+
+func (r secretsManagerResolver) client(state *RefreshState) *secretsmanager.SecretsManager {
+	if state.SecretsManagerClient == nil {
+		state.SecretsManagerClient = secretsmanager.New(state.Session, state.Config)
+	}
+	return state.SecretsManagerClient
+}
+
+func (r secretsManagerResolver) Resolve(source Source, state *RefreshState) (interface{}, error) {
+	secret, err := r.parse(source.Identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := secretsManagerGetSecretValue(r.client(state), secret)
+	if err != nil {
+		return nil, err
+	}
+	if secret.Path == "" {
+		return value, nil
+	}
+
+	res, err := extractJSONPath(value, secret.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s from secret %s: %s", secret.Path, secret.SecretID, err)
+	}
+	return res, nil
+}
+
+// versionID returns the id of the version with the stage of secret, or of
+// the pinned version if it exists.
+func (r secretsManagerResolver) versionID(secret *secretsManagerSecret, state *RefreshState) (string, error) {
+	res, err := r.client(state).DescribeSecret(&secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(secret.SecretID),
+	})
+	if err != nil {
+		return "", err
+	}
+	for versionID, stages := range res.VersionIdsToStages {
+		if versionID == secret.VersionID {
+			return versionID, nil
+		}
+		for _, stage := range stages {
+			if aws.StringValue(stage) == secret.VersionStage {
+				return versionID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// Version returns the id of the version of the secret to resolve.
+func (r secretsManagerResolver) Version(source Source, state *RefreshState) (string, error) {
+	secret, err := r.parse(source.Identifier)
+	if err != nil {
+		return "", err
+	}
+	if secret.VersionID != "" {
+		return secret.VersionID, nil
+	}
+	return r.versionID(secret, state)
+}
+
+// Validate checks the secret and the version to resolve exist with DescribeSecret.
+func (r secretsManagerResolver) Validate(source Source, state *RefreshState) error {
+	secret, err := r.parse(source.Identifier)
+	if err != nil {
+		return err
+	}
+
+	versionID, err := r.versionID(secret, state)
+	if err != nil {
+		return err
+	}
+	if versionID == "" {
+		if secret.VersionID != "" {
+			return fmt.Errorf("Version %s of secret %s not found", secret.VersionID, secret.SecretID)
+		}
+		return fmt.Errorf("No version of secret %s with stage %s", secret.SecretID, secret.VersionStage)
+	}
+	return nil
+}
+
+func secretsManagerGetSecretValue(secretsManagerClient *secretsmanager.SecretsManager, secret *secretsManagerSecret) (interface{}, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secret.SecretID),
+	}
+	if secret.VersionID != "" {
+		input.VersionId = aws.String(secret.VersionID)
+	} else {
+		input.VersionStage = aws.String(secret.VersionStage)
+	}
+
+	result, err := secretsManagerClient.GetSecretValue(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var content []byte
+	if result.SecretString != nil {
+		content = []byte(*result.SecretString)
+	} else {
+		decodedBinarySecretBytes := make([]byte, base64.StdEncoding.DecodedLen(len(result.SecretBinary)))
+		len, err := base64.StdEncoding.Decode(decodedBinarySecretBytes, result.SecretBinary)
+		if err != nil {
+			return nil, err
+		}
+		content = decodedBinarySecretBytes[:len]
+	}
+
+	return decodeSecret(content)
+}
+
+// decodeSecret decodes JSON objects and arrays, other values are kept as strings.
+func decodeSecret(content []byte) (interface{}, error) {
+	trimmed := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return string(content), nil
+	}
+
+	var res interface{}
+	err := unmarshalJSONNumbers(content, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// extractJSONPath returns the field at path in a decoded JSON value. The path
+// is made of field names separated by dots and array indexes, like a.b[0].c,
+// with an optional leading $.
+func extractJSONPath(value interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.Replace(path, "[", ".", -1)
+	path = strings.Replace(path, "]", "", -1)
+	if path == "" {
+		return value, nil
+	}
+
+	current := value
+	for _, part := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			child, ok := node[part]
+			if !ok {
+				return nil, fmt.Errorf("Field %s not found", part)
+			}
+			current = child
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("Invalid index %s", part)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("Field %s not found, value is not an object or an array", part)
+		}
+	}
+	return current, nil
+}
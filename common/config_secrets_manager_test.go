@@ -0,0 +1,116 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSecretsManagerIdentifier(t *testing.T) {
+	version := "6d1a4a5e-3f2b-4c8e-9a7d-0b1c2d3e4f50"
+	token := "rotation-2024-01-01-0000000000000001"
+	tests := map[string]secretsManagerSecret{
+		"db":                                  {SecretID: "db"},
+		"app/db#hosts[0].name":                {SecretID: "app/db", Path: "hosts[0].name"},
+		"db@AWSPREVIOUS":                      {SecretID: "db", VersionStage: "AWSPREVIOUS"},
+		"db@AWSPENDING#password":              {SecretID: "db", VersionStage: "AWSPENDING", Path: "password"},
+		"db?version=" + version:               {SecretID: "db", VersionID: version},
+		"db?version=" + version + "#password": {SecretID: "db", VersionID: version, Path: "password"},
+		"db?version=" + token + "#password":   {SecretID: "db", VersionID: token, Path: "password"},
+		"db#" + version:                       {SecretID: "db", Path: version},
+		"user@example.com":                    {SecretID: "user@example.com"},
+		"user@example.com@AWSCURRENT#key":     {SecretID: "user@example.com", VersionStage: "AWSCURRENT", Path: "key"},
+	}
+	for identifier, expected := range tests {
+		secret, err := parseSecretsManagerIdentifier(identifier)
+		require.NoError(t, err, identifier)
+		assert.Equal(t, expected, *secret, identifier)
+	}
+
+	for _, identifier := range []string{"db@AWSCURRENT?version=" + version, "db?version=short", "db?stage=AWSCURRENT"} {
+		_, err := parseSecretsManagerIdentifier(identifier)
+		assert.Error(t, err, identifier)
+	}
+}
+
+func TestSecretsManagerResolverParse(t *testing.T) {
+	secret, err := secretsManagerResolver{}.parse("db")
+	require.NoError(t, err)
+	assert.Equal(t, "AWSCURRENT", secret.VersionStage)
+
+	secret, err = secretsManagerResolver{VersionStage: "AWSPENDING"}.parse("db")
+	require.NoError(t, err)
+	assert.Equal(t, "AWSPENDING", secret.VersionStage)
+
+	secret, err = secretsManagerResolver{VersionStage: "AWSPENDING"}.parse("db@AWSPREVIOUS")
+	require.NoError(t, err)
+	assert.Equal(t, "AWSPREVIOUS", secret.VersionStage)
+
+	secret, err = secretsManagerResolver{VersionStage: "AWSPENDING"}.parse("db?version=6d1a4a5e-3f2b-4c8e-9a7d-0b1c2d3e4f50")
+	require.NoError(t, err)
+	assert.Equal(t, "", secret.VersionStage)
+}
+
+func TestDecodeSecret(t *testing.T) {
+	value, err := decodeSecret([]byte(`{"password": "secret", "port": 5432, "hosts": [{"name": "a"}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"password": "secret",
+		"port":     json.Number("5432"),
+		"hosts":    []interface{}{map[string]interface{}{"name": "a"}},
+	}, value)
+
+	value, err = decodeSecret([]byte("plain-text"))
+	require.NoError(t, err)
+	assert.Equal(t, "plain-text", value)
+
+	_, err = decodeSecret([]byte("{invalid"))
+	assert.Error(t, err)
+}
+
+func TestDecodeSecretAccountID(t *testing.T) {
+	value, err := decodeSecret([]byte(`{"account_id": 123456789012, "ratio": 0.5}`))
+	require.NoError(t, err)
+
+	c := NewConfigValues()
+	c.Static = map[string]interface{}{"SECRETS_MANAGER__ACCOUNT": Source{Type: "SECRETS_MANAGER"}}
+	env, err := c.Environment(map[string]interface{}{"SECRETS_MANAGER__ACCOUNT": value}, false)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"ACCOUNT_ID": "123456789012", "RATIO": "0.5"}, env)
+
+	c = NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "SECRETS_MANAGER",
+		ValuePrefix: "secrets-manager://",
+		Resolver:    fakeResolver{"account": value.(map[string]interface{})["account_id"]},
+	})
+	require.NoError(t, c.SetFromMap(map[string]interface{}{"role": "arn:aws:iam::${secrets-manager:account}:role/ops"}))
+	output := map[string]interface{}{}
+	require.NoError(t, c.Refresh(nil, nil, &output))
+	assert.Equal(t, "arn:aws:iam::123456789012:role/ops", output["role"])
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	value, err := decodeSecret([]byte(`{"password": "secret", "port": 5432, "hosts": [{"name": "a"}, {"name": "b"}]}`))
+	require.NoError(t, err)
+
+	tests := map[string]interface{}{
+		"password":      "secret",
+		"$.password":    "secret",
+		"port":          json.Number("5432"),
+		"hosts[1].name": "b",
+		"hosts.0.name":  "a",
+	}
+	for path, expected := range tests {
+		res, err := extractJSONPath(value, path)
+		require.NoError(t, err, path)
+		assert.Equal(t, expected, res, path)
+	}
+
+	for _, path := range []string{"missing", "hosts[2]", "hosts[x]", "password.length"} {
+		_, err := extractJSONPath(value, path)
+		assert.Error(t, err, path)
+	}
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SourceResolver fetches the value of a source when refreshing ConfigValues.
+// The value replaces the source in the refreshed map, it can be a string or
+// any JSON compatible value.
+type SourceResolver interface {
+	Resolve(source Source, state *RefreshState) (interface{}, error)
+}
+
+type SourceResolverFunc func(source Source, state *RefreshState) (interface{}, error)
+
+func (f SourceResolverFunc) Resolve(source Source, state *RefreshState) (interface{}, error) {
+	return f(source, state)
+}
+
+// SourceBatchResolver is implemented by resolvers which can fetch many sources
+// in fewer calls. Prefetch is called with all the sources of its type before
+// they are resolved one by one.
+type SourceBatchResolver interface {
+	Prefetch(sources []Source, state *RefreshState) error
+}
+
+// SourceDefinition describes how a source type is referenced in a config and how it is resolved.
+type SourceDefinition struct {
+	Type        string
+	KeyPrefix   string
+	ValuePrefix string
+	Resolver    SourceResolver
+}
+
+var sourceDefinitions = map[string]*SourceDefinition{}
+
+// RegisterSource adds a source type available to every ConfigValues created afterwards.
+// Registering an existing type replaces it.
+func RegisterSource(definition *SourceDefinition) {
+	if _, ok := sourceDefinitions[definition.Type]; !ok {
+		SourceTypes = append(SourceTypes, definition.Type)
+	}
+	sourceDefinitions[definition.Type] = definition
+}
+
+func init() {
+	RegisterSource(&SourceDefinition{
+		Type:        "KMS",
+		KeyPrefix:   "KMS_",
+		ValuePrefix: "kms://",
+		Resolver:    funcResolver{SourceResolverFunc(resolveKMS), versionKMS, validateKMS},
+	})
+	RegisterSource(&SourceDefinition{
+		Type:        "SSM",
+		KeyPrefix:   "SSM_",
+		ValuePrefix: "ssm://",
+		Resolver:    ssmResolver{},
+	})
+	RegisterSource(&SourceDefinition{
+		Type:        "SECRETS_MANAGER",
+		KeyPrefix:   "SECRETS_MANAGER_",
+		ValuePrefix: "secrets-manager://",
+		Resolver:    secretsManagerResolver{},
+	})
+	RegisterSource(&SourceDefinition{
+		Type:        "S3",
+		KeyPrefix:   "S3_",
+		ValuePrefix: "s3://",
+		Resolver:    funcResolver{SourceResolverFunc(resolveS3), versionS3, validateS3},
+	})
+	RegisterSource(&SourceDefinition{
+		Type:        "FILE",
+		KeyPrefix:   "FILE_",
+		ValuePrefix: "file://",
+		Resolver:    funcResolver{SourceResolverFunc(resolveFile), versionFile, validateFile},
+	})
+}
+
+func resolveFile(source Source, state *RefreshState) (interface{}, error) {
+	bytes, err := ioutil.ReadFile(source.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	return string(bytes), nil
+}
+
+func resolveKMS(source Source, state *RefreshState) (interface{}, error) {
+	if state.KMSClient == nil {
+		state.KMSClient = kms.New(state.Session, state.Config)
+	}
+	value, err := DecryptWithKMS(state.KMSClient, source.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	return string(value), nil
+}
+
+type s3Object struct {
+	Bucket    string
+	Key       string
+	VersionID string
+	JSON      bool
+}
+
+// parseS3Identifier parses bucket/key[?version=ID&format=json].
+func parseS3Identifier(identifier string) (*s3Object, error) {
+	query := ""
+	if i := strings.Index(identifier, "?"); i >= 0 {
+		query = identifier[i+1:]
+		identifier = identifier[:i]
+	}
+
+	parts := strings.SplitN(identifier, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("Invalid S3 source %s, should be bucket/key", identifier)
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	object := &s3Object{
+		Bucket:    parts[0],
+		Key:       parts[1],
+		VersionID: values.Get("version"),
+	}
+
+	switch values.Get("format") {
+	case "", "raw":
+	case "json":
+		object.JSON = true
+	default:
+		return nil, fmt.Errorf("Invalid S3 source format %s, should be raw or json", values.Get("format"))
+	}
+	return object, nil
+}
+
+func resolveS3(source Source, state *RefreshState) (interface{}, error) {
+	object, err := parseS3Identifier(source.Identifier)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.S3Client == nil {
+		state.S3Client = s3.New(state.Session, state.Config)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Key),
+	}
+	if object.VersionID != "" {
+		input.VersionId = aws.String(object.VersionID)
+	}
+
+	res, err := state.S3Client.GetObject(input)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	content, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if !object.JSON {
+		return string(content), nil
+	}
+
+	var value interface{}
+	err = unmarshalJSONNumbers(content, &value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,237 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// ssmGetParametersBatchSize is the maximum number of names accepted by GetParameters.
+const ssmGetParametersBatchSize = 10
+
+// ssmResolver resolves SSM parameters. Identifiers ending with /* resolve to
+// a map of the parameters directly under the path and identifiers ending
+// with /** to a nested map of all the parameters under the path.
+type ssmResolver struct{}
+
+// isSSMPath returns whether identifier is a /* or a recursive /** path.
+func isSSMPath(identifier string) bool {
+	return strings.HasSuffix(identifier, "/*") || isSSMRecursivePath(identifier)
+}
+
+func isSSMRecursivePath(identifier string) bool {
+	return strings.HasSuffix(identifier, "/**")
+}
+
+func (r ssmResolver) client(state *RefreshState) *ssm.SSM {
+	if state.SSMClient == nil {
+		state.SSMClient = ssm.New(state.Session, state.Config)
+	}
+	return state.SSMClient
+}
+
+func (r ssmResolver) Resolve(source Source, state *RefreshState) (interface{}, error) {
+	if isSSMRecursivePath(source.Identifier) {
+		path := strings.TrimSuffix(source.Identifier, "/**")
+		parameters, err := getParametersByPath(r.client(state), path, true)
+		if err != nil {
+			return nil, err
+		}
+		return ssmParameterTree(path, parameters)
+	}
+	if isSSMPath(source.Identifier) {
+		path := strings.TrimSuffix(source.Identifier, "/*")
+		parameters, err := getParametersByPath(r.client(state), path, false)
+		if err != nil {
+			return nil, err
+		}
+		result := map[string]string{}
+		for name, value := range parameters {
+			parts := strings.Split(name, "/")
+			result[parts[len(parts)-1]] = value
+		}
+		return result, nil
+	}
+
+	if parameter, ok := state.ssmParameters[source.Identifier]; ok {
+		return aws.StringValue(parameter.Value), nil
+	}
+	return ssmGetParameter(r.client(state), source.Identifier)
+}
+
+// Version does not decrypt the parameter, paths are not versioned.
+func (r ssmResolver) Version(source Source, state *RefreshState) (string, error) {
+	if isSSMPath(source.Identifier) {
+		return "", nil
+	}
+
+	parameter, ok := state.ssmParameters[source.Identifier]
+	if !ok {
+		res, err := r.client(state).GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(source.Identifier),
+			WithDecryption: aws.Bool(false),
+		})
+		if err != nil {
+			return "", err
+		}
+		parameter = res.Parameter
+	}
+	return fmt.Sprintf("%d", aws.Int64Value(parameter.Version)), nil
+}
+
+// Validate uses DescribeParameters which does not return values, paths
+// without parameters are valid.
+func (r ssmResolver) Validate(source Source, state *RefreshState) error {
+	filter := &ssm.ParameterStringFilter{
+		Key:    aws.String("Name"),
+		Option: aws.String("Equals"),
+		Values: aws.StringSlice([]string{source.Identifier}),
+	}
+	if isSSMPath(source.Identifier) {
+		option := "OneLevel"
+		path := strings.TrimSuffix(source.Identifier, "/*")
+		if isSSMRecursivePath(source.Identifier) {
+			option = "Recursive"
+			path = strings.TrimSuffix(source.Identifier, "/**")
+		}
+		if path == "" {
+			path = "/"
+		}
+		filter = &ssm.ParameterStringFilter{
+			Key:    aws.String("Path"),
+			Option: aws.String(option),
+			Values: aws.StringSlice([]string{path}),
+		}
+	}
+
+	res, err := r.client(state).DescribeParameters(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{filter},
+	})
+	if err != nil {
+		return err
+	}
+	if len(res.Parameters) == 0 && !isSSMPath(source.Identifier) {
+		return fmt.Errorf("SSM parameter %s not found", source.Identifier)
+	}
+	return nil
+}
+
+// Prefetch gets single parameters with GetParameters, 10 at a time.
+// Parameters with a version or label selector and ARNs are fetched one by one.
+func (r ssmResolver) Prefetch(sources []Source, state *RefreshState) error {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, source := range sources {
+		name := source.Identifier
+		if isSSMPath(name) || strings.Contains(name, ":") || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) < 2 {
+		return nil
+	}
+
+	if state.ssmParameters == nil {
+		state.ssmParameters = map[string]*ssm.Parameter{}
+	}
+
+	for start := 0; start < len(names); start += ssmGetParametersBatchSize {
+		end := start + ssmGetParametersBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		res, err := r.client(state).GetParameters(&ssm.GetParametersInput{
+			Names:          aws.StringSlice(names[start:end]),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return err
+		}
+		if len(res.InvalidParameters) > 0 {
+			return fmt.Errorf("SSM parameters not found: %s", strings.Join(aws.StringValueSlice(res.InvalidParameters), ", "))
+		}
+		for _, parameter := range res.Parameters {
+			state.ssmParameters[aws.StringValue(parameter.Name)] = parameter
+		}
+	}
+	return nil
+}
+
+// getParametersByPath returns the values of the parameters under path by full name.
+func getParametersByPath(client *ssm.SSM, path string, recursive bool) (map[string]string, error) {
+	if path == "" {
+		path = "/"
+	}
+
+	result := map[string]string{}
+	err := client.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(recursive),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, parameter := range page.Parameters {
+			result[aws.StringValue(parameter.Name)] = aws.StringValue(parameter.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ssmParameterTree nests parameters by the components of their name after path,
+// /app/db/user under /app becomes {"db": {"user": value}}.
+func ssmParameterTree(path string, parameters map[string]string) (map[string]interface{}, error) {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	tree := map[string]interface{}{}
+	for _, name := range names {
+		parts := strings.Split(strings.TrimPrefix(name, prefix), "/")
+
+		node := tree
+		for i, part := range parts {
+			if i == len(parts)-1 {
+				if _, ok := node[part]; ok {
+					return nil, fmt.Errorf("SSM parameter %s conflicts with a parameter path", name)
+				}
+				node[part] = parameters[name]
+				break
+			}
+
+			switch child := node[part].(type) {
+			case nil:
+				next := map[string]interface{}{}
+				node[part] = next
+				node = next
+			case map[string]interface{}:
+				node = child
+			default:
+				return nil, fmt.Errorf("SSM parameter %s conflicts with a parameter path", name)
+			}
+		}
+	}
+	return tree, nil
+}
+
+func ssmGetParameter(ssmClient *ssm.SSM, name string) (string, error) {
+	res, err := ssmClient.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return *res.Parameter.Value, nil
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSM serves GetParameter, GetParameters, GetParametersByPath and
+// DescribeParameters from parameters, returning one parameter per
+// GetParametersByPath page.
+type fakeSSM struct {
+	parameters map[string]string
+	calls      map[string]int
+	names      []string
+	filters    []string
+}
+
+// under returns the names of the parameters under path, which ends with /.
+func (f *fakeSSM) under(path string, recursive bool) []string {
+	names := []string{}
+	for name := range f.parameters {
+		if !strings.HasPrefix(name, path) {
+			continue
+		}
+		if !recursive && strings.Contains(name[len(path):], "/") {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (f *fakeSSM) parameter(name string) map[string]interface{} {
+	return map[string]interface{}{"Name": name, "Value": f.parameters[name], "Version": 1}
+}
+
+func (f *fakeSSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AmazonSSM.")
+	f.calls[operation]++
+
+	input := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	output := map[string]interface{}{}
+	switch operation {
+	case "GetParameter":
+		f.names = append(f.names, input["Name"].(string))
+		output["Parameter"] = f.parameter(input["Name"].(string))
+	case "GetParameters":
+		parameters := []interface{}{}
+		for _, name := range input["Names"].([]interface{}) {
+			f.names = append(f.names, name.(string))
+			parameters = append(parameters, f.parameter(name.(string)))
+		}
+		output["Parameters"] = parameters
+	case "GetParametersByPath":
+		path := strings.TrimSuffix(input["Path"].(string), "/") + "/"
+		recursive, _ := input["Recursive"].(bool)
+		names := f.under(path, recursive)
+		start := 0
+		if token, ok := input["NextToken"].(string); ok {
+			fmt.Sscanf(token, "%d", &start)
+		}
+		output["Parameters"] = []interface{}{}
+		if start < len(names) {
+			sort.Strings(names)
+			output["Parameters"] = []interface{}{f.parameter(names[start])}
+			if start+1 < len(names) {
+				output["NextToken"] = fmt.Sprintf("%d", start+1)
+			}
+		}
+	case "DescribeParameters":
+		filter := input["ParameterFilters"].([]interface{})[0].(map[string]interface{})
+		value := filter["Values"].([]interface{})[0].(string)
+		f.filters = append(f.filters, fmt.Sprintf("%s %s %s", filter["Key"], filter["Option"], value))
+
+		names := []string{}
+		if filter["Key"] == "Path" {
+			names = f.under(strings.TrimSuffix(value, "/")+"/", filter["Option"] == "Recursive")
+		} else if _, ok := f.parameters[value]; ok {
+			names = []string{value}
+		}
+		parameters := []interface{}{}
+		for _, name := range names {
+			parameters = append(parameters, map[string]interface{}{"Name": name})
+		}
+		output["Parameters"] = parameters
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(output)
+}
+
+func newFakeSSMState(t *testing.T, parameters map[string]string) (*RefreshState, *fakeSSM, *httptest.Server) {
+	fake := &fakeSSM{parameters: parameters, calls: map[string]int{}}
+	server := httptest.NewServer(fake)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	return &RefreshState{Session: sess, Config: &aws.Config{}}, fake, server
+}
+
+func TestSSMResolverPaths(t *testing.T) {
+	state, fake, server := newFakeSSMState(t, map[string]string{
+		"/app/a":       "1",
+		"/app/b":       "2",
+		"/app/db/user": "user",
+		"/app/db/pass": "pass",
+		"/other/c":     "3",
+	})
+	defer server.Close()
+
+	value, err := ssmResolver{}.Resolve(Source{Type: "SSM", Identifier: "/app/*"}, state)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, value)
+	assert.Equal(t, 2, fake.calls["GetParametersByPath"])
+
+	value, err = ssmResolver{}.Resolve(Source{Type: "SSM", Identifier: "/app/**"}, state)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": "1",
+		"b": "2",
+		"db": map[string]interface{}{
+			"user": "user",
+			"pass": "pass",
+		},
+	}, value)
+}
+
+func TestSSMResolverPrefetch(t *testing.T) {
+	parameters := map[string]string{}
+	c := NewConfigValues()
+	static := map[string]interface{}{}
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("/app/%02d", i)
+		parameters[name] = fmt.Sprintf("value-%d", i)
+		static[fmt.Sprintf("k%d", i)] = "ssm://" + name
+	}
+	require.NoError(t, c.SetFromMap(static))
+
+	state, fake, server := newFakeSSMState(t, parameters)
+	defer server.Close()
+	output := map[string]string{}
+	require.NoError(t, c.Refresh(state.Session, state.Config, &output))
+
+	assert.Equal(t, 3, fake.calls["GetParameters"])
+	assert.Equal(t, 0, fake.calls["GetParameter"])
+	assert.Equal(t, "value-7", output["k7"])
+}
+
+func TestSSMResolverRecursivePathIsNotAParameter(t *testing.T) {
+	state, fake, server := newFakeSSMState(t, map[string]string{
+		"/app/a":       "1",
+		"/app/db/user": "user",
+		"/b":           "2",
+		"/c":           "3",
+	})
+	defer server.Close()
+
+	sources := []Source{
+		{Type: "SSM", Identifier: "/app/**"},
+		{Type: "SSM", Identifier: "/b"},
+		{Type: "SSM", Identifier: "/c"},
+	}
+	require.NoError(t, ssmResolver{}.Prefetch(sources, state))
+	assert.Equal(t, []string{"/b", "/c"}, fake.names)
+
+	version, err := ssmResolver{}.Version(sources[0], state)
+	require.NoError(t, err)
+	assert.Equal(t, "", version)
+	assert.Equal(t, 0, fake.calls["GetParameter"])
+}
+
+func TestSSMResolverValidate(t *testing.T) {
+	state, fake, server := newFakeSSMState(t, map[string]string{
+		"/app/db/user": "user",
+		"/b":           "2",
+	})
+	defer server.Close()
+
+	for _, identifier := range []string{"/app/**", "/app/*", "/other/**", "/b"} {
+		assert.NoError(t, ssmResolver{}.Validate(Source{Type: "SSM", Identifier: identifier}, state), identifier)
+	}
+	assert.EqualError(t, ssmResolver{}.Validate(Source{Type: "SSM", Identifier: "/missing"}, state), "SSM parameter /missing not found")
+
+	assert.Equal(t, []string{
+		"Path Recursive /app",
+		"Path OneLevel /app",
+		"Path Recursive /other",
+		"Name Equals /b",
+		"Name Equals /missing",
+	}, fake.filters)
+}
+
+func TestSSMParameterTree(t *testing.T) {
+	tree, err := ssmParameterTree("/", map[string]string{"/a": "1", "/b/c": "2"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": map[string]interface{}{"c": "2"}}, tree)
+
+	_, err = ssmParameterTree("/app", map[string]string{"/app/a": "1", "/app/a/b": "2"})
+	assert.Error(t, err)
+}
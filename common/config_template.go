@@ -0,0 +1,129 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Template is a string with references to sources like ${ssm:/db/host},
+// the scheme is the value prefix of the source type without ://.
+// $${ is kept as a literal ${, like ${HOME} or other text which is not a
+// reference to a known source.
+type Template struct {
+	Name string
+	Text string
+	// Parts are literal strings and Sources.
+	Parts []interface{}
+}
+
+func (c *ConfigValues) templateSchemes() map[string]string {
+	schemes := map[string]string{}
+	for sourceType, prefix := range c.ValuePrefixes {
+		schemes[strings.TrimSuffix(prefix, "://")] = sourceType
+	}
+	return schemes
+}
+
+// parseTemplate returns text unescaped when it has no references.
+func (c *ConfigValues) parseTemplate(name, text string) interface{} {
+	if !strings.Contains(text, "${") {
+		return text
+	}
+
+	schemes := c.templateSchemes()
+	template := &Template{Name: name, Text: text}
+	literal := bytes.Buffer{}
+	hasSources := false
+
+	rest := text
+	for {
+		i := strings.Index(rest, "${")
+		if i < 0 {
+			literal.WriteString(rest)
+			break
+		}
+
+		if i > 0 && rest[i-1] == '$' {
+			literal.WriteString(rest[:i-1])
+			literal.WriteString("${")
+			rest = rest[i+2:]
+			continue
+		}
+		literal.WriteString(rest[:i])
+
+		end := strings.Index(rest[i:], "}")
+		if end < 0 {
+			literal.WriteString(rest[i:])
+			break
+		}
+		reference := rest[i+2 : i+end]
+		rest = rest[i+end+1:]
+
+		parts := strings.SplitN(reference, ":", 2)
+		sourceType, ok := schemes[parts[0]]
+		if len(parts) != 2 || parts[1] == "" || !ok {
+			literal.WriteString("${" + reference + "}")
+			continue
+		}
+
+		if literal.Len() > 0 {
+			template.Parts = append(template.Parts, literal.String())
+			literal.Reset()
+		}
+		template.Parts = append(template.Parts, Source{
+			Type:       sourceType,
+			Name:       name,
+			Identifier: parts[1],
+		})
+		hasSources = true
+	}
+
+	if !hasSources {
+		return literal.String()
+	}
+	if literal.Len() > 0 {
+		template.Parts = append(template.Parts, literal.String())
+	}
+	return template
+}
+
+// Render resolves the sources of the template, they must resolve to strings,
+// numbers or booleans.
+func (t *Template) Render(state *RefreshState) (string, error) {
+	res := bytes.Buffer{}
+
+	for _, part := range t.Parts {
+		source, ok := part.(Source)
+		if !ok {
+			res.WriteString(part.(string))
+			continue
+		}
+
+		resolver, err := state.resolver(source.Type)
+		if err != nil {
+			return "", err
+		}
+		value, err := state.resolve(resolver, source)
+		if err != nil {
+			return "", fmt.Errorf("Failed to resolve %s %s in %s: %s", source.Type, source.Identifier, t.Name, err)
+		}
+
+		switch value := value.(type) {
+		case string:
+			res.WriteString(value)
+		case json.Number:
+			res.WriteString(value.String())
+		case float64:
+			res.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+		case bool:
+			res.WriteString(strconv.FormatBool(value))
+		default:
+			return "", fmt.Errorf("%s %s in %s does not resolve to a string", source.Type, source.Identifier, t.Name)
+		}
+	}
+
+	return res.String(), nil
+}
@@ -0,0 +1,207 @@
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// SourceValidator is implemented by resolvers which can check a source exists
+// and is accessible without fetching its value.
+type SourceValidator interface {
+	Validate(source Source, state *RefreshState) error
+}
+
+// ExplainEntry describes a value of the config. Type is STATIC for values
+// which are not loaded from a source.
+type ExplainEntry struct {
+	Key        string
+	Type       string
+	Identifier string
+	Error      error
+}
+
+// walkValues calls fn with the path of every value in src, templates call fn for each of their sources.
+func walkValues(key string, value interface{}, fn func(key string, value interface{})) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			path := k
+			if key != "" && k != "" {
+				path = key + "." + k
+			} else if k == "" {
+				path = key
+			}
+			walkValues(path, value[k], fn)
+		}
+	case []interface{}:
+		for i, item := range value {
+			walkValues(fmt.Sprintf("%s[%d]", key, i), item, fn)
+		}
+	case *Template:
+		for _, part := range value.Parts {
+			if source, ok := part.(Source); ok {
+				fn(key, source)
+			}
+		}
+	default:
+		fn(key, value)
+	}
+}
+
+func (c *ConfigValues) newRefreshState(session *session.Session, conf *aws.Config) *RefreshState {
+	return &RefreshState{
+		Session:   session,
+		Config:    conf,
+		Resolvers: c.Resolvers,
+		Cache:     c.Cache,
+	}
+}
+
+// Validate checks every source without fetching secret values. Sources whose
+// resolver does not implement SourceValidator are only checked to have a known type.
+func (c *ConfigValues) Validate(session *session.Session, conf *aws.Config) error {
+	state := c.newRefreshState(session, conf)
+
+	problems := []string{}
+	walkValues("", c.Static, func(key string, value interface{}) {
+		source, ok := value.(Source)
+		if !ok {
+			return
+		}
+
+		resolver, err := state.resolver(source.Type)
+		if err == nil {
+			if validator, ok := resolver.(SourceValidator); ok {
+				err = validator.Validate(source, state)
+			}
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s (%s %s): %s", key, source.Type, source.Identifier, err))
+		}
+	})
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid config:\n%s", strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// Explain resolves every source of the config independently and reports
+// whether it resolved, resolved values are not included.
+func (c *ConfigValues) Explain(session *session.Session, conf *aws.Config) []ExplainEntry {
+	state := c.newRefreshState(session, conf)
+
+	entries := []ExplainEntry{}
+	walkValues("", c.Static, func(key string, value interface{}) {
+		source, ok := value.(Source)
+		if !ok {
+			entries = append(entries, ExplainEntry{Key: key, Type: "STATIC"})
+			return
+		}
+
+		resolver, err := state.resolver(source.Type)
+		if err == nil {
+			_, err = state.resolve(resolver, source)
+		}
+		entries = append(entries, ExplainEntry{
+			Key:        key,
+			Type:       source.Type,
+			Identifier: source.Identifier,
+			Error:      err,
+		})
+	})
+	return entries
+}
+
+// WriteExplain writes the entries as a table and returns the number of
+// entries which failed to resolve.
+func WriteExplain(w io.Writer, entries []ExplainEntry) int {
+	failed := 0
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tIDENTIFIER\tSTATUS")
+	for _, entry := range entries {
+		identifier := entry.Identifier
+		if len(identifier) > 40 {
+			identifier = identifier[:37] + "..."
+		}
+
+		status := "ok"
+		if entry.Error != nil {
+			status = fmt.Sprintf("error: %s", entry.Error)
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", entry.Key, entry.Type, identifier, status)
+	}
+	tw.Flush()
+
+	return failed
+}
+
+func validateFile(source Source, state *RefreshState) error {
+	f, err := os.Open(source.Identifier)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// validateKMS checks the key of envelopes exists, the key used by other
+// ciphertexts is not known without decrypting them.
+func validateKMS(source Source, state *RefreshState) error {
+	content, err := base64.StdEncoding.DecodeString(source.Identifier)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("Empty ciphertext")
+	}
+
+	e, err := parseEnvelope(content)
+	if err != nil || e == nil {
+		return err
+	}
+
+	if state.KMSClient == nil {
+		state.KMSClient = kms.New(state.Session, state.Config)
+	}
+	_, err = state.KMSClient.DescribeKey(&kms.DescribeKeyInput{
+		KeyId: aws.String(e.KeyID),
+	})
+	return err
+}
+
+func validateS3(source Source, state *RefreshState) error {
+	object, err := parseS3Identifier(source.Identifier)
+	if err != nil {
+		return err
+	}
+
+	if state.S3Client == nil {
+		state.S3Client = s3.New(state.Session, state.Config)
+	}
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(object.Bucket),
+		Key:    aws.String(object.Key),
+	}
+	if object.VersionID != "" {
+		input.VersionId = aws.String(object.VersionID)
+	}
+	_, err = state.S3Client.HeadObject(input)
+	return err
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type validatingResolver struct {
+	fakeResolver
+	valid map[string]bool
+}
+
+func (r validatingResolver) Validate(source Source, state *RefreshState) error {
+	if !r.valid[source.Identifier] {
+		return fmt.Errorf("%s not accessible", source.Identifier)
+	}
+	return nil
+}
+
+func newValidateConfig(t *testing.T) *ConfigValues {
+	c := NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "SSM",
+		ValuePrefix: "ssm://",
+		Resolver: validatingResolver{
+			fakeResolver: fakeResolver{"/a": "value-a", "/b": "value-b"},
+			valid:        map[string]bool{"/a": true, "/b": true},
+		},
+	})
+	err := c.SetFromMap(map[string]interface{}{
+		"a":      "ssm:///a",
+		"static": "value",
+		"nested": map[string]interface{}{
+			"list": []interface{}{"ssm:///b", "ssm:///missing"},
+			"dsn":  "${ssm:/a}@host",
+		},
+	})
+	require.NoError(t, err)
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	c := newValidateConfig(t)
+
+	err := c.Validate(nil, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nested.list[1] (SSM /missing): /missing not accessible")
+	assert.NotContains(t, err.Error(), "/b")
+
+	delete(c.Static["nested"].(map[string]interface{}), "list")
+	assert.NoError(t, c.Validate(nil, nil))
+}
+
+func TestExplain(t *testing.T) {
+	c := newValidateConfig(t)
+
+	entries := c.Explain(nil, nil)
+	require.Len(t, entries, 5)
+	assert.Equal(t, ExplainEntry{Key: "a", Type: "SSM", Identifier: "/a"}, entries[0])
+	assert.Equal(t, ExplainEntry{Key: "nested.dsn", Type: "SSM", Identifier: "/a"}, entries[1])
+	assert.Equal(t, "nested.list[1]", entries[3].Key)
+	assert.Error(t, entries[3].Error)
+	assert.Equal(t, ExplainEntry{Key: "static", Type: "STATIC"}, entries[4])
+
+	buf := &bytes.Buffer{}
+	assert.Equal(t, 1, WriteExplain(buf, entries))
+	assert.False(t, strings.Contains(buf.String(), "value-a"))
+	assert.Contains(t, buf.String(), "error: /missing not found")
+}
+
+func TestRefreshWithRetriesKeepsError(t *testing.T) {
+	c := newValidateConfig(t)
+	c.MaxRetries = 1
+
+	output := map[string]interface{}{}
+	err := c.RefreshWithRetries(nil, nil, &output)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to refresh config: /missing not found")
+}
@@ -1,23 +1,26 @@
 package common
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io/ioutil"
+	"reflect"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
 )
 
-var SourceTypes = []string{"KMS", "SSM", "SECRETS_MANAGER", "FILE"}
+// SourceTypes lists the registered source types, see RegisterSource.
+var SourceTypes = []string{}
 
 type Source struct {
 	Type       string
@@ -31,26 +34,57 @@ type ConfigValues struct {
 	MaxRetries    int
 	KeyPrefixes   map[string]string
 	ValuePrefixes map[string]string
+	Resolvers     map[string]SourceResolver
+	// Cache keeps resolved values between refreshes when set, see EnableCache.
+	Cache *SourceCache
+
+	callbacks []ChangeCallback
+	previous  map[string]interface{}
+	m         sync.Mutex
 }
 
+// ChangeCallback is called with the previous and current resolved values
+// after a refresh changed them.
+type ChangeCallback func(previous, current map[string]interface{})
+
 func NewConfigValues() *ConfigValues {
-	return &ConfigValues{
-		Sources:    map[string][]Source{},
-		Static:     map[string]interface{}{},
-		MaxRetries: 5,
-		KeyPrefixes: map[string]string{
-			"KMS":             "KMS_",
-			"SSM":             "SSM_",
-			"SECRETS_MANAGER": "SECRETS_MANAGER_",
-			"FILE":            "FILE_",
-		},
-		ValuePrefixes: map[string]string{
-			"KMS":             "kms://",
-			"SSM":             "ssm://",
-			"SECRETS_MANAGER": "secrets-manager://",
-			"FILE":            "file://",
-		},
+	c := &ConfigValues{
+		Sources:       map[string][]Source{},
+		Static:        map[string]interface{}{},
+		MaxRetries:    5,
+		KeyPrefixes:   map[string]string{},
+		ValuePrefixes: map[string]string{},
+		Resolvers:     map[string]SourceResolver{},
+	}
+	for _, definition := range sourceDefinitions {
+		c.RegisterSource(definition)
 	}
+	return c
+}
+
+// RegisterSource adds or replaces a source type for this config only.
+func (c *ConfigValues) RegisterSource(definition *SourceDefinition) {
+	if definition.KeyPrefix != "" {
+		c.KeyPrefixes[definition.Type] = definition.KeyPrefix
+	}
+	if definition.ValuePrefix != "" {
+		c.ValuePrefixes[definition.Type] = definition.ValuePrefix
+	}
+	c.Resolvers[definition.Type] = definition.Resolver
+}
+
+// EnableCache keeps resolved values for ttl, after which versioned sources
+// are only fetched again if their version changed.
+func (c *ConfigValues) EnableCache(ttl time.Duration) {
+	c.Cache = NewSourceCache(ttl)
+}
+
+// OnChange adds a callback called when a refresh resolves different values
+// than the previous one. It is not called on the first refresh.
+func (c *ConfigValues) OnChange(callback ChangeCallback) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.callbacks = append(c.callbacks, callback)
 }
 
 func (c *ConfigValues) Clear() {
@@ -78,6 +112,12 @@ func (c *ConfigValues) GenerateFromMap(src map[string]interface{}) (map[string]i
 				return nil, err
 			}
 			dst[key] = val
+		case []interface{}:
+			val, err := c.generateSlice(key, value.([]interface{}))
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = val
 		case string:
 			found := false
 			for secretType, prefix := range c.KeyPrefixes {
@@ -100,25 +140,53 @@ func (c *ConfigValues) GenerateFromMap(src map[string]interface{}) (map[string]i
 			}
 
 			if !found {
-				for secretType, prefix := range c.ValuePrefixes {
-					if strings.HasPrefix(value.(string), prefix) {
-						value := value.(string)[len(prefix):]
-						dst[key] = Source{
-							Type:       secretType,
-							Name:       key,
-							Identifier: value,
-						}
-						found = true
-						break
-					}
-				}
+				dst[key] = c.generateValue(key, value.(string))
 			}
+		default:
+			dst[key] = value
+		}
+	}
 
-			if !found {
-				dst[key] = value
+	return dst, nil
+}
+
+// generateValue returns a Source if value starts with a value prefix or a
+// Template if it contains references.
+func (c *ConfigValues) generateValue(key string, value string) interface{} {
+	for secretType, prefix := range c.ValuePrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return Source{
+				Type:       secretType,
+				Name:       key,
+				Identifier: value[len(prefix):],
+			}
+		}
+	}
+	return c.parseTemplate(key, value)
+}
+
+// generateSlice only looks for value prefixes, key prefixes do not apply to arrays.
+func (c *ConfigValues) generateSlice(key string, src []interface{}) ([]interface{}, error) {
+	dst := make([]interface{}, len(src))
+
+	for i, value := range src {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			val, err := c.GenerateFromMap(value)
+			if err != nil {
+				return nil, err
 			}
+			dst[i] = val
+		case []interface{}:
+			val, err := c.generateSlice(key, value)
+			if err != nil {
+				return nil, err
+			}
+			dst[i] = val
+		case string:
+			dst[i] = c.generateValue(key, value)
 		default:
-			dst[key] = value
+			dst[i] = value
 		}
 	}
 
@@ -135,187 +203,240 @@ func (c *ConfigValues) SetFromMap(m map[string]interface{}) error {
 	return nil
 }
 
+// IsRefreshable returns whether the config has values loaded from sources.
 func (c *ConfigValues) IsRefreshable() bool {
-	return len(c.Sources) > 0
+	sources := map[string][]Source{}
+	collectSources(c.Static, sources)
+	return len(c.Sources) > 0 || len(sources) > 0
 }
 
 func (c *ConfigValues) RefreshWithRetries(session *session.Session, conf *aws.Config, output interface{}) error {
+	return c.RefreshWithRetriesContext(context.Background(), session, conf, output)
+}
+
+// RefreshWithRetriesContext retries with an exponential backoff, it stops
+// waiting when ctx is done.
+func (c *ConfigValues) RefreshWithRetriesContext(ctx context.Context, session *session.Session, conf *aws.Config, output interface{}) error {
 
 	wait := 2
+	var err error
 
 	for i := 0; i < c.MaxRetries; i++ {
-		err := c.Refresh(session, conf, output)
+		err = c.Refresh(session, conf, output)
 		if err == nil {
 			return nil
 		}
+		if i == c.MaxRetries-1 {
+			break
+		}
 
 		wait = wait * 2
-		time.Sleep(time.Duration(wait) * time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(wait) * time.Second):
+		}
+	}
+	if err == nil {
+		return errors.New("Failed to refresh config")
+	}
+	return errors.Wrap(err, "Failed to refresh config")
+}
+
+// Watch refreshes the config every interval until ctx is done. Use OnChange
+// to get the new values.
+func (c *ConfigValues) Watch(ctx context.Context, session *session.Session, conf *aws.Config, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		output := map[string]interface{}{}
+		err := c.RefreshWithRetriesContext(ctx, session, conf, &output)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
 	}
-	return errors.New("Failed to refresh config")
 }
 
 type RefreshState struct {
 	Session              *session.Session
 	Config               *aws.Config
+	Resolvers            map[string]SourceResolver
 	STSClient            *sts.STS
 	SecretsManagerClient *secretsmanager.SecretsManager
 	KMSClient            *kms.KMS
 	SSMClient            *ssm.SSM
+	S3Client             *s3.S3
+	Cache                *SourceCache
+
+	ssmParameters map[string]*ssm.Parameter
 }
 
 func (c *ConfigValues) Refresh(session *session.Session, conf *aws.Config, output interface{}) error {
-	state := &RefreshState{
-		Session: session,
-		Config:  conf,
+	state := c.newRefreshState(session, conf)
+	err := state.prefetch(c.Static)
+	if err != nil {
+		return err
 	}
 	env, err := RefreshMap(c.Static, state)
 	if err != nil {
 		return err
 	}
+	c.notifyChange(env)
 
 	data, err := json.Marshal(env)
 	if err != nil {
 		return err
 	}
 
-	return json.Unmarshal(data, output)
+	return unmarshalJSONNumbers(data, output)
 }
 
-func RefreshMap(src map[string]interface{}, state *RefreshState) (map[string]interface{}, error) {
-	dst := map[string]interface{}{}
+func (c *ConfigValues) notifyChange(current map[string]interface{}) {
+	c.m.Lock()
+	previous := c.previous
+	c.previous = current
+	callbacks := c.callbacks
+	c.m.Unlock()
 
-	for key, value := range src {
-		switch value.(type) {
+	if previous == nil || reflect.DeepEqual(previous, current) {
+		return
+	}
+	for _, callback := range callbacks {
+		callback(previous, current)
+	}
+}
+
+// collectSources returns the sources in src by type.
+func collectSources(src map[string]interface{}, sources map[string][]Source) {
+	for _, value := range src {
+		switch value := value.(type) {
 		case map[string]interface{}:
-			res, err := RefreshMap(value.(map[string]interface{}), state)
-			if err != nil {
-				return nil, err
+			collectSources(value, sources)
+		case []interface{}:
+			for _, item := range value {
+				collectSources(map[string]interface{}{"": item}, sources)
 			}
-			dst[key] = res
 		case Source:
-			source := value.(Source)
-			switch source.Type {
-			case "FILE":
-				bytes, err := ioutil.ReadFile(source.Identifier)
-				if err != nil {
-					return nil, err
-				}
-				dst[source.Name] = string(bytes)
-			case "SSM":
-				if state.SSMClient == nil {
-					state.SSMClient = ssm.New(state.Session, state.Config)
-				}
-				if strings.HasSuffix(source.Identifier, "/*") {
-					values, err := getParametersByPath(state.SSMClient, source.Identifier[:len(source.Identifier)-2])
-					if err != nil {
-						return nil, err
-					}
-					dst[key] = values
-				} else {
-					value, err := ssmGetParameter(state.SSMClient, source.Identifier)
-					if err != nil {
-						return nil, err
-					}
-					dst[source.Name] = value
-				}
-			case "SECRETS_MANAGER":
-				if state.SecretsManagerClient == nil {
-					state.SecretsManagerClient = secretsmanager.New(state.Session, state.Config)
-				}
-				values, err := secretsManagerGetSecretValue(state.SecretsManagerClient, source.Identifier, source.Name)
-				if err != nil {
-					return nil, err
-				}
-				dst[key] = values
-			case "KMS":
-				if state.KMSClient == nil {
-					state.KMSClient = kms.New(state.Session, state.Config)
-				}
-				value, err := DecryptWithKMS(state.KMSClient, source.Identifier)
-				if err != nil {
-					return nil, err
+			sources[value.Type] = append(sources[value.Type], value)
+		case *Template:
+			for _, part := range value.Parts {
+				if source, ok := part.(Source); ok {
+					sources[source.Type] = append(sources[source.Type], source)
 				}
-				dst[source.Name] = string(value)
 			}
-		default:
-			dst[key] = value
 		}
 	}
-
-	return dst, nil
 }
 
-func getParametersByPath(client *ssm.SSM, path string) (map[string]string, error) {
-	res, err := client.GetParametersByPath(&ssm.GetParametersByPathInput{
-		Path:           aws.String(path),
-		WithDecryption: aws.Bool(true),
-	})
-	if err != nil {
-		return nil, err
-	}
-	result := map[string]string{}
+// prefetch lets resolvers implementing SourceBatchResolver fetch the sources
+// missing from the cache together.
+func (s *RefreshState) prefetch(src map[string]interface{}) error {
+	sources := map[string][]Source{}
+	collectSources(src, sources)
 
-	for _, parameter := range res.Parameters {
-		parts := strings.Split(*parameter.Name, "/")
-		key := parts[len(parts)-1]
+	for sourceType, typeSources := range sources {
+		resolver, err := s.resolver(sourceType)
+		if err != nil {
+			return err
+		}
+		batchResolver, ok := resolver.(SourceBatchResolver)
+		if !ok {
+			continue
+		}
 
-		result[key] = *parameter.Value
+		missing := []Source{}
+		for _, source := range typeSources {
+			if s.Cache == nil || !s.Cache.fresh(source) {
+				missing = append(missing, source)
+			}
+		}
+		if len(missing) == 0 {
+			continue
+		}
+		err = batchResolver.Prefetch(missing, s)
+		if err != nil {
+			return err
+		}
 	}
-
-	return result, nil
+	return nil
 }
 
-func ConvertMap(source map[string]string, prefix string) map[string]string {
-	res := make(map[string]string, len(source))
-	for key, value := range source {
-		var newKey string
-		if prefix == "" {
-			newKey = strings.ToUpper(key)
-		} else {
-			newKey = fmt.Sprintf("%s_%s", prefix, strings.ToUpper(key))
-		}
-		res[newKey] = value
+func (s *RefreshState) resolve(resolver SourceResolver, source Source) (interface{}, error) {
+	if s.Cache != nil {
+		return s.Cache.Resolve(resolver, source, s)
 	}
-	return res
+	return resolver.Resolve(source, s)
 }
 
-func ssmGetParameter(ssmClient *ssm.SSM, name string) (string, error) {
-	res, err := ssmClient.GetParameter(&ssm.GetParameterInput{
-		Name:           aws.String(name),
-		WithDecryption: aws.Bool(true),
-	})
-	if err != nil {
-		return "", err
+func (s *RefreshState) resolver(sourceType string) (SourceResolver, error) {
+	if resolver, ok := s.Resolvers[sourceType]; ok {
+		return resolver, nil
+	}
+	if definition, ok := sourceDefinitions[sourceType]; ok {
+		return definition.Resolver, nil
 	}
-	return *res.Parameter.Value, nil
+	return nil, fmt.Errorf("Unknown source type %s", sourceType)
 }
 
-func secretsManagerGetSecretValue(secretsManagerClient *secretsmanager.SecretsManager, secretName, prefix string) (map[string]string, error) {
-	result, err := secretsManagerClient.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: aws.String("AWSCURRENT"),
-	})
-	if err != nil {
-		return nil, err
+func RefreshMap(src map[string]interface{}, state *RefreshState) (map[string]interface{}, error) {
+	dst := map[string]interface{}{}
+
+	for key, value := range src {
+		switch value.(type) {
+		case map[string]interface{}:
+			res, err := RefreshMap(value.(map[string]interface{}), state)
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = res
+		case []interface{}:
+			res, err := refreshSlice(value.([]interface{}), state)
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = res
+		case Source:
+			source := value.(Source)
+			resolver, err := state.resolver(source.Type)
+			if err != nil {
+				return nil, err
+			}
+			res, err := state.resolve(resolver, source)
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = res
+		case *Template:
+			res, err := value.(*Template).Render(state)
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = res
+		default:
+			dst[key] = value
+		}
 	}
 
-	var content []byte
-	if result.SecretString != nil {
-		content = []byte(*result.SecretString)
-	} else {
-		decodedBinarySecretBytes := make([]byte, base64.StdEncoding.DecodedLen(len(result.SecretBinary)))
-		len, err := base64.StdEncoding.Decode(decodedBinarySecretBytes, result.SecretBinary)
+	return dst, nil
+}
+
+func refreshSlice(src []interface{}, state *RefreshState) ([]interface{}, error) {
+	dst := make([]interface{}, len(src))
+
+	for i, value := range src {
+		res, err := RefreshMap(map[string]interface{}{"": value}, state)
 		if err != nil {
 			return nil, err
 		}
-		content = decodedBinarySecretBytes[:len]
+		dst[i] = res[""]
 	}
 
-	res := make(map[string]string)
-	err = json.Unmarshal(content, &res)
-	if err != nil {
-		return nil, err
-	}
-	return res, nil
+	return dst, nil
 }
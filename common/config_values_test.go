@@ -1,15 +1,16 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/hamstah/awstools/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -25,11 +26,25 @@ func TestMain(m *testing.M) {
 }
 
 func setup() {
-	sess = session.Must(session.NewSessionWithOptions(session.Options{
+	var err error
+	sess, err = session.NewSessionWithOptions(session.Options{
 		Profile:           "hamstah",
 		SharedConfigState: session.SharedConfigEnable,
-	}))
-	conf = common.NewConfig("eu-west-1")
+	})
+	if err == nil {
+		_, err = sess.Config.Credentials.Get()
+	}
+	if err != nil {
+		sess = nil
+	}
+	conf = NewConfig("eu-west-1")
+}
+
+// requireAWS skips tests using real AWS resources when the hamstah profile is not available.
+func requireAWS(t *testing.T) {
+	if sess == nil {
+		t.Skip("hamstah AWS profile not available")
+	}
 }
 
 type Data struct {
@@ -99,6 +114,7 @@ func TestSetFromMapWithValuePrefixes(t *testing.T) {
       "f": "kms://value-to-decrypt",
       "g": "file://config_values.go",
       "h": "def",
+      "j": "s3://bucket/path/to/key?version=abc",
       "i": 789
     }
   }
@@ -112,6 +128,7 @@ func TestSetFromMapWithValuePrefixes(t *testing.T) {
 	assert.Equal(t, Source{Type: "SECRETS_MANAGER", Name: "e", Identifier: "secret-name"}, nested["e"])
 	assert.Equal(t, Source{Type: "KMS", Name: "f", Identifier: "value-to-decrypt"}, nested["f"])
 	assert.Equal(t, Source{Type: "FILE", Name: "g", Identifier: "config_values.go"}, nested["g"])
+	assert.Equal(t, Source{Type: "S3", Name: "j", Identifier: "bucket/path/to/key?version=abc"}, nested["j"])
 	assert.Equal(t, "def", nested["h"])
 	assert.Equal(t, float64(789), nested["i"])
 }
@@ -124,6 +141,7 @@ type Struct1 struct {
 }
 
 func TestResolveSSMValuePrefixes(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "a": "ssm:///hamstah/awstools/tests/test-1/key-1",
     "b": {
@@ -150,6 +168,7 @@ type SSM2 struct {
 }
 
 func TestResolveSSMValuePrefixesWildcard(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "a": "ssm:///hamstah/awstools/tests/test-1/*"
   }
@@ -167,6 +186,7 @@ func TestResolveSSMValuePrefixesWildcard(t *testing.T) {
 }
 
 func TestResolveSSMKeyPrefixes(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "SSM_a": "/hamstah/awstools/tests/test-1/key-1",
     "b": {
@@ -186,6 +206,7 @@ func TestResolveSSMKeyPrefixes(t *testing.T) {
 }
 
 func TestResolveSSMKeyPrefixesWildcard(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "SSM_a": "/hamstah/awstools/tests/test-1/*"
   }
@@ -216,6 +237,7 @@ type SecretManager1 struct {
 }
 
 func TestResolveSecretsManagerValuePrefixes(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "a": "secrets-manager://hamstah/awstools/tests/test-1",
     "b": {
@@ -238,6 +260,7 @@ func TestResolveSecretsManagerValuePrefixes(t *testing.T) {
 }
 
 func TestResolveSecretsManagerKeyPrefixes(t *testing.T) {
+	requireAWS(t)
 	data := `{
     "SECRETS_MANAGER_a": "hamstah/awstools/tests/test-1",
     "b": {
@@ -260,6 +283,7 @@ func TestResolveSecretsManagerKeyPrefixes(t *testing.T) {
 }
 
 func TestResolveKMSKeyPrefixes(t *testing.T) {
+	requireAWS(t)
 	kmsValue := "AQICAHgn4TiP+IVP5oaT3N1aUybKUHg7vUly/WosR5LPrHVjnQEMLudv32oTAQWoX6HniNdEAAAAYzBhBgkqhkiG9w0BBwagVDBSAgEAME0GCSqGSIb3DQEHATAeBglghkgBZQMEAS4wEQQM/V5L24Ql0EtrGRgKAgEQgCArH5vr2jJJJErnnv2o/xuf/eKBg2fdVFcX92hbcKi1Ng=="
 	data := fmt.Sprintf(`{
     "KMS_a": "%s",
@@ -280,6 +304,7 @@ func TestResolveKMSKeyPrefixes(t *testing.T) {
 }
 
 func TestResolveKMSSecretBoxKeyPrefixes(t *testing.T) {
+	requireAWS(t)
 	kmsValue := "NP+BAwEBB3BheWxvYWQB/4IAAQMBA0tleQEKAAEFTm9uY2UB/4QAAQdNZXNzYWdlAQoAAAAZ/4MBAQEJWzI0XXVpbnQ4Af+EAAEGATAAAP/r/4IB/6gBAgMAeCfhOI/4hU/mhpPc3VpTJspQeDu9SXL9aixHks+sdWOdAScFLzbtavfzeThGQcMxSC8AAABuMGwGCSqGSIb3DQEHBqBfMF0CAQAwWAYJKoZIhvcNAQcBMB4GCWCGSAFlAwQBLjARBAyhDR1pFVAkoFoCVWYCARCAKw5BfzV6W31ZYNzIYgcT0+LI5pEekbXSf09o7AuIrSs5yu4LI/waNDH2P94BGG3/6XxeAwF2Wwn/0/+7/6j/5/+0ACr/5/+e/7D/7nf/kEr/wwEVKcbN1sW5fOl3/A2JPW+dDLXC3+dPAA=="
 	data := fmt.Sprintf(`{
     "KMS_a": "%s",
@@ -300,6 +325,7 @@ func TestResolveKMSSecretBoxKeyPrefixes(t *testing.T) {
 }
 
 func TestEncryptDecryptKMSWithSecretBox(t *testing.T) {
+	requireAWS(t)
 	kmsClient := kms.New(sess, conf)
 	plaintext := []byte("value")
 	keyId := "41694ce4-3596-455c-89ae-b36f9e20566a"
@@ -311,3 +337,235 @@ func TestEncryptDecryptKMSWithSecretBox(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, plaintext, decrypted)
 }
+
+type fakeResolver map[string]interface{}
+
+func (f fakeResolver) Resolve(source Source, state *RefreshState) (interface{}, error) {
+	value, ok := f[source.Identifier]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", source.Identifier)
+	}
+	return value, nil
+}
+
+func TestRefreshWithFakeResolvers(t *testing.T) {
+	c := NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "SSM",
+		KeyPrefix:   "SSM_",
+		ValuePrefix: "ssm://",
+		Resolver: fakeResolver{
+			"/path/key-1": "value-1",
+			"/path/key-2": "value-2",
+			"/path/*":     map[string]string{"key-1": "value-1", "key-2": "value-2"},
+		},
+	})
+
+	m := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+    "a": "ssm:///path/key-1",
+    "b": {
+      "SSM_c": "/path/key-2"
+    }
+  }`), &m)
+	require.NoError(t, err)
+	require.NoError(t, c.SetFromMap(m))
+
+	d := Struct1{}
+	err = c.Refresh(nil, nil, &d)
+	require.NoError(t, err)
+	assert.Equal(t, "value-1", d.A)
+	assert.Equal(t, "value-2", d.B.C)
+
+	require.NoError(t, c.SetFromMap(map[string]interface{}{"a": "ssm:///path/*"}))
+	d2 := SSM2{}
+	err = c.Refresh(nil, nil, &d2)
+	require.NoError(t, err)
+	assert.Equal(t, "value-1", d2.A.Value1)
+	assert.Equal(t, "value-2", d2.A.Value2)
+
+	require.NoError(t, c.SetFromMap(map[string]interface{}{"a": "ssm:///missing"}))
+	assert.Error(t, c.Refresh(nil, nil, &d))
+}
+
+func TestRegisterNewSourceType(t *testing.T) {
+	c := NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "CUSTOM",
+		KeyPrefix:   "CUSTOM_",
+		ValuePrefix: "custom://",
+		Resolver: SourceResolverFunc(func(source Source, state *RefreshState) (interface{}, error) {
+			return "resolved-" + source.Identifier, nil
+		}),
+	})
+
+	err := c.SetFromMap(map[string]interface{}{
+		"a": "custom://x",
+		"b": map[string]interface{}{
+			"CUSTOM_c": "y",
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Source{Type: "CUSTOM", Name: "a", Identifier: "x"}, c.Static["a"])
+
+	d := Struct1{}
+	err = c.Refresh(nil, nil, &d)
+	require.NoError(t, err)
+	assert.Equal(t, "resolved-x", d.A)
+	assert.Equal(t, "resolved-y", d.B.C)
+}
+
+func TestRefreshUnknownSourceType(t *testing.T) {
+	c := NewConfigValues()
+	c.Static = map[string]interface{}{
+		"a": Source{Type: "UNKNOWN", Name: "a", Identifier: "x"},
+	}
+	d := Struct1{}
+	assert.Error(t, c.Refresh(nil, nil, &d))
+}
+
+func TestParseS3Identifier(t *testing.T) {
+	object, err := parseS3Identifier("bucket/path/to/key")
+	require.NoError(t, err)
+	assert.Equal(t, &s3Object{Bucket: "bucket", Key: "path/to/key"}, object)
+
+	object, err = parseS3Identifier("bucket/key.json?version=abc&format=json")
+	require.NoError(t, err)
+	assert.Equal(t, &s3Object{Bucket: "bucket", Key: "key.json", VersionID: "abc", JSON: true}, object)
+
+	for _, identifier := range []string{"bucket", "bucket/", "/key", "bucket/key?format=yaml"} {
+		_, err = parseS3Identifier(identifier)
+		assert.Error(t, err, identifier)
+	}
+}
+
+type countingResolver struct {
+	values   map[string]string
+	versions map[string]string
+	calls    map[string]int
+}
+
+func newCountingResolver() *countingResolver {
+	return &countingResolver{
+		values:   map[string]string{},
+		versions: map[string]string{},
+		calls:    map[string]int{},
+	}
+}
+
+func (r *countingResolver) Resolve(source Source, state *RefreshState) (interface{}, error) {
+	r.calls[source.Identifier]++
+	return r.values[source.Identifier], nil
+}
+
+func (r *countingResolver) Version(source Source, state *RefreshState) (string, error) {
+	return r.versions[source.Identifier], nil
+}
+
+func newCountingConfig(t *testing.T, resolver *countingResolver) *ConfigValues {
+	c := NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "COUNTING",
+		ValuePrefix: "counting://",
+		Resolver:    resolver,
+	})
+	require.NoError(t, c.SetFromMap(map[string]interface{}{"a": "counting://x"}))
+	return c
+}
+
+func TestRefreshCache(t *testing.T) {
+	resolver := newCountingResolver()
+	resolver.values["x"] = "value-1"
+	resolver.versions["x"] = "1"
+	c := newCountingConfig(t, resolver)
+	c.EnableCache(time.Hour)
+
+	d := Struct1{}
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	assert.Equal(t, "value-1", d.A)
+	assert.Equal(t, 1, resolver.calls["x"])
+
+	// expired but same version
+	c.Cache.TTL = 0
+	resolver.values["x"] = "value-2"
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	assert.Equal(t, "value-1", d.A)
+	assert.Equal(t, 1, resolver.calls["x"])
+
+	resolver.versions["x"] = "2"
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	assert.Equal(t, "value-2", d.A)
+	assert.Equal(t, 2, resolver.calls["x"])
+
+	// unversioned sources are fetched once expired
+	resolver.versions["x"] = ""
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	assert.Equal(t, 3, resolver.calls["x"])
+}
+
+func TestRefreshOnChange(t *testing.T) {
+	resolver := newCountingResolver()
+	resolver.values["x"] = "value-1"
+	c := newCountingConfig(t, resolver)
+
+	changes := []map[string]interface{}{}
+	c.OnChange(func(previous, current map[string]interface{}) {
+		assert.Equal(t, "value-1", previous["a"])
+		changes = append(changes, current)
+	})
+
+	d := Struct1{}
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	assert.Len(t, changes, 0)
+
+	resolver.values["x"] = "value-2"
+	require.NoError(t, c.Refresh(nil, nil, &d))
+	require.Len(t, changes, 1)
+	assert.Equal(t, "value-2", changes[0]["a"])
+}
+
+func TestRefreshWithRetriesContextCancel(t *testing.T) {
+	c := NewConfigValues()
+	c.Static = map[string]interface{}{
+		"a": Source{Type: "UNKNOWN", Name: "a", Identifier: "x"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	d := Struct1{}
+	err := c.RefreshWithRetriesContext(ctx, nil, nil, &d)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRefreshArrays(t *testing.T) {
+	c := NewConfigValues()
+	c.RegisterSource(&SourceDefinition{
+		Type:        "SSM",
+		KeyPrefix:   "SSM_",
+		ValuePrefix: "ssm://",
+		Resolver:    fakeResolver{"/a": "value-a", "/b": "value-b", "/c": "value-c"},
+	})
+
+	m := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+    "hosts": ["ssm:///a", "static", 1, ["ssm:///b"], {"SSM_c": "/c"}]
+  }`), &m)
+	require.NoError(t, err)
+	require.NoError(t, c.SetFromMap(m))
+	assert.Equal(t, Source{Type: "SSM", Name: "hosts", Identifier: "/a"}, c.Static["hosts"].([]interface{})[0])
+
+	output := map[string]interface{}{}
+	require.NoError(t, c.Refresh(nil, nil, &output))
+	assert.Equal(t, []interface{}{
+		"value-a",
+		"static",
+		json.Number("1"),
+		[]interface{}{"value-b"},
+		map[string]interface{}{"c": "value-c"},
+	}, output["hosts"])
+}
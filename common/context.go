@@ -0,0 +1,165 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+/*
+current-context: prod
+contexts:
+  prod:
+    flags:
+      region: eu-west-1
+      assume-role-arn: arn:aws:iam::123456789012:role/ops
+    tools:
+      ecs-deploy:
+        cluster: main
+*/
+
+type ToolConfig struct {
+	CurrentContext string                  `yaml:"current-context"`
+	Contexts       map[string]*ToolContext `yaml:"contexts"`
+}
+
+// ToolContext holds default flag values. Flags apply to every tool that has
+// them, Tools only to the tool with that name.
+type ToolContext struct {
+	Flags map[string]interface{}            `yaml:"flags"`
+	Tools map[string]map[string]interface{} `yaml:"tools"`
+}
+
+func KingpinContextFlags() *string {
+	return kingpin.Flag("context", "Name of the context to use from the awstools config file").String()
+}
+
+func ToolConfigFilename() (string, bool) {
+	if filename := os.Getenv("AWSTOOLS_CONFIG"); filename != "" {
+		return filename, true
+	}
+	return os.ExpandEnv("$HOME/.config/awstools/config.yaml"), false
+}
+
+func LoadToolConfig(filename string) (*ToolConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ToolConfig{}
+	err = yaml.Unmarshal(data, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid config file %s", filepath.Base(filename))
+	}
+	return config, nil
+}
+
+// ContextArgs returns args with the flag values of the selected context
+// added for every flag not already present in args. Values for the tool
+// replace the values of the shared flags.
+func ContextArgs(app *kingpin.Application, config *ToolConfig, args []string) ([]string, error) {
+	name := contextFromArgs(args)
+	if name == "" {
+		name = os.Getenv("AWSTOOLS_CONTEXT")
+	}
+	if name == "" {
+		name = config.CurrentContext
+	}
+	if name == "" {
+		return args, nil
+	}
+
+	context, ok := config.Contexts[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown context %s", name)
+	}
+
+	// tool values replace the shared values of the same flag
+	values := map[string]interface{}{}
+	for flagName, value := range context.Flags {
+		if app.GetFlag(flagName) == nil {
+			// shared flags only apply to the tools that have them
+			continue
+		}
+		values[flagName] = value
+	}
+
+	for flagName, value := range context.Tools[app.Name] {
+		if app.GetFlag(flagName) == nil {
+			return nil, fmt.Errorf("Unknown flag %s for %s in context %s", flagName, app.Name, name)
+		}
+		values[flagName] = value
+	}
+
+	// sort by flag name only to keep the order of the values of repeatable flags
+	flagNames := make([]string, 0, len(values))
+	for flagName := range values {
+		flagNames = append(flagNames, flagName)
+	}
+	sort.Strings(flagNames)
+
+	defaults := []string{}
+	for _, flagName := range flagNames {
+		defaults = append(defaults, contextFlagArgs(app.GetFlag(flagName), flagName, values[flagName], args)...)
+	}
+	return append(defaults, args...), nil
+}
+
+func contextFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--context" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--context=") {
+			return arg[len("--context="):]
+		}
+	}
+	return ""
+}
+
+func hasFlagArg(name string, args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name || arg == "--no-"+name || strings.HasPrefix(arg, "--"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func contextFlagArgs(flag *kingpin.FlagClause, name string, value interface{}, args []string) []string {
+	if hasFlagArg(name, args) {
+		return nil
+	}
+
+	if flag.Model().IsBoolFlag() {
+		if enabled, ok := value.(bool); ok && !enabled {
+			return []string{"--no-" + name}
+		}
+		return []string{"--" + name}
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		res = append(res, fmt.Sprintf("--%s=%v", name, v))
+	}
+	return res
+}
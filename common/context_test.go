@@ -0,0 +1,109 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+func newContextApp() *kingpin.Application {
+	app := kingpin.New("ecs-deploy", "")
+	app.Flag("context", "").String()
+	app.Flag("region", "").String()
+	app.Flag("cluster", "").String()
+	app.Flag("image", "").Strings()
+	app.Flag("wait", "").Bool()
+	return app
+}
+
+func newToolConfig() *ToolConfig {
+	return &ToolConfig{
+		CurrentContext: "dev",
+		Contexts: map[string]*ToolContext{
+			"dev": &ToolContext{
+				Flags: map[string]interface{}{
+					"region":          "eu-west-1",
+					"assume-role-arn": "arn:aws:iam::123456789012:role/dev",
+				},
+			},
+			"prod": &ToolContext{
+				Flags: map[string]interface{}{
+					"region": "us-east-1",
+				},
+				Tools: map[string]map[string]interface{}{
+					"ecs-deploy": map[string]interface{}{
+						"cluster": "main",
+						"image":   []interface{}{"a", "b"},
+						"wait":    false,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestContextArgsCurrentContext(t *testing.T) {
+	args, err := ContextArgs(newContextApp(), newToolConfig(), []string{"--cluster=other"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--region=eu-west-1", "--cluster=other"}, args)
+}
+
+func TestContextArgsSelectedContext(t *testing.T) {
+	args, err := ContextArgs(newContextApp(), newToolConfig(), []string{"--context", "prod", "--region", "eu-west-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--cluster=main",
+		"--image=a",
+		"--image=b",
+		"--no-wait",
+		"--context", "prod", "--region", "eu-west-2",
+	}, args)
+}
+
+func TestContextArgsUnknownContext(t *testing.T) {
+	_, err := ContextArgs(newContextApp(), newToolConfig(), []string{"--context=staging"})
+	assert.Error(t, err)
+}
+
+func TestContextArgsUnknownToolFlag(t *testing.T) {
+	config := newToolConfig()
+	config.Contexts["prod"].Tools["ecs-deploy"]["services"] = "web"
+	_, err := ContextArgs(newContextApp(), config, []string{"--context=prod"})
+	assert.Error(t, err)
+}
+
+func TestContextArgsToolOverridesFlags(t *testing.T) {
+	config := newToolConfig()
+	config.Contexts["prod"].Tools["ecs-deploy"]["region"] = "eu-central-1"
+	args, err := ContextArgs(newContextApp(), config, []string{"--context=prod"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"--cluster=main",
+		"--image=a",
+		"--image=b",
+		"--region=eu-central-1",
+		"--no-wait",
+		"--context=prod",
+	}, args)
+
+	_, err = newContextApp().Parse(args)
+	assert.NoError(t, err)
+}
+
+func TestContextArgsRepeatedFlagOrder(t *testing.T) {
+	config := newToolConfig()
+	config.Contexts["prod"].Tools["ecs-deploy"]["image"] = []interface{}{"z", "b", "a:latest", "--c"}
+	args, err := ContextArgs(newContextApp(), config, []string{"--context=prod"})
+	require.NoError(t, err)
+
+	images := []string{}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--image=") {
+			images = append(images, arg[len("--image="):])
+		}
+	}
+	assert.Equal(t, []string{"z", "b", "a:latest", "--c"}, images)
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// EndpointServices maps the names used in --endpoint-url-<name> flags and
+// AWS_ENDPOINT_URL_<NAME> environment variables to SDK endpoint IDs.
+var EndpointServices = map[string]string{
+	"acm":            "acm",
+	"cloudwatch":     "monitoring",
+	"ec2":            "ec2",
+	"ecr":            "api.ecr",
+	"ecs":            "ecs",
+	"elb":            "elasticloadbalancing",
+	"iam":            "iam",
+	"kms":            "kms",
+	"lambda":         "lambda",
+	"route53":        "route53",
+	"s3":             "s3",
+	"secretsmanager": "secretsmanager",
+	"ssm":            "ssm",
+	"sts":            "sts",
+}
+
+// OfflineRegion is the region used with --offline when none is set, the
+// default region of local emulators.
+const OfflineRegion = "us-east-1"
+
+var offlineMode bool
+
+type EndpointFlags struct {
+	URL         *string
+	ServiceURLs map[string]*string
+	Offline     *bool
+}
+
+func KingpinEndpointFlags() *EndpointFlags {
+	flags := &EndpointFlags{
+		URL:         kingpin.Flag("endpoint-url", "Override the endpoint URL of every AWS service, use --endpoint-url-<service> for a single service").String(),
+		ServiceURLs: map[string]*string{},
+		Offline:     kingpin.Flag("offline", "Use test credentials and skip the instance metadata for local emulators, requires an endpoint URL").Envar("AWSTOOLS_OFFLINE").Bool(),
+	}
+	for name := range EndpointServices {
+		flags.ServiceURLs[name] = kingpin.Flag(
+			fmt.Sprintf("endpoint-url-%s", name),
+			fmt.Sprintf("Override the endpoint URL of %s", name),
+		).Hidden().String()
+	}
+	return flags
+}
+
+// HandleEndpointFlags enables the offline mode. It requires an endpoint
+// override so the test credentials are never sent to AWS.
+func HandleEndpointFlags(flags *EndpointFlags) {
+	if !*flags.Offline {
+		return
+	}
+	if !flags.hasOverrides() {
+		Fatalln("--offline requires --endpoint-url or AWS_ENDPOINT_URL")
+	}
+	offlineMode = true
+}
+
+// applyOffline sets the credentials of the environment, or test credentials
+// accepted by local emulators, so the instance metadata is never queried.
+func applyOffline(conf *aws.Config) {
+	if !offlineMode || conf.Credentials != nil {
+		return
+	}
+	conf.Credentials = credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvProvider{},
+		&credentials.StaticProvider{Value: credentials.Value{AccessKeyID: "test", SecretAccessKey: "test"}},
+	})
+}
+
+func endpointEnvName(name string) string {
+	if name == "" {
+		return "AWS_ENDPOINT_URL"
+	}
+	return fmt.Sprintf("AWS_ENDPOINT_URL_%s", strings.ToUpper(name))
+}
+
+// ServiceEndpointURL returns the endpoint override for an SDK endpoint ID.
+// Service specific values win over global ones and flags win over the environment.
+func (f *EndpointFlags) ServiceEndpointURL(service string) string {
+	for name, id := range EndpointServices {
+		if id != service {
+			continue
+		}
+		if f != nil && f.ServiceURLs[name] != nil && *f.ServiceURLs[name] != "" {
+			return *f.ServiceURLs[name]
+		}
+		if value := os.Getenv(endpointEnvName(name)); value != "" {
+			return value
+		}
+	}
+
+	if f != nil && f.URL != nil && *f.URL != "" {
+		return *f.URL
+	}
+	return os.Getenv(endpointEnvName(""))
+}
+
+func (f *EndpointFlags) hasOverrides() bool {
+	if f.ServiceEndpointURL("") != "" {
+		return true
+	}
+	for _, id := range EndpointServices {
+		if f.ServiceEndpointURL(id) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyEndpoints installs an endpoint resolver on conf when any endpoint
+// override is set. flags can be nil to only use the environment.
+func ApplyEndpoints(conf *aws.Config, flags *EndpointFlags) {
+	if !flags.hasOverrides() {
+		return
+	}
+
+	defaultResolver := endpoints.DefaultResolver()
+	conf.EndpointResolver = endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		url := flags.ServiceEndpointURL(service)
+		if url == "" {
+			return defaultResolver.EndpointFor(service, region, opts...)
+		}
+		return endpoints.ResolvedEndpoint{
+			URL:           url,
+			SigningRegion: region,
+		}, nil
+	})
+
+	if flags.ServiceEndpointURL(EndpointServices["s3"]) != "" {
+		// local emulators and VPC endpoints don't resolve bucket subdomains
+		conf.S3ForcePathStyle = aws.Bool(true)
+	}
+}
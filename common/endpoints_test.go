@@ -0,0 +1,99 @@
+package common
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceEndpointURL(t *testing.T) {
+	os.Setenv("AWS_ENDPOINT_URL", "http://env-global")
+	os.Setenv("AWS_ENDPOINT_URL_SSM", "http://env-ssm")
+	defer os.Unsetenv("AWS_ENDPOINT_URL")
+	defer os.Unsetenv("AWS_ENDPOINT_URL_SSM")
+
+	var noFlags *EndpointFlags
+	assert.Equal(t, "http://env-global", noFlags.ServiceEndpointURL("ecs"))
+	assert.Equal(t, "http://env-ssm", noFlags.ServiceEndpointURL("ssm"))
+
+	flags := &EndpointFlags{
+		URL: aws.String("http://flag-global"),
+		ServiceURLs: map[string]*string{
+			"ecs": aws.String("http://flag-ecs"),
+		},
+	}
+	assert.Equal(t, "http://flag-ecs", flags.ServiceEndpointURL("ecs"))
+	assert.Equal(t, "http://env-ssm", flags.ServiceEndpointURL("ssm"))
+	assert.Equal(t, "http://flag-global", flags.ServiceEndpointURL("kms"))
+}
+
+func TestApplyEndpoints(t *testing.T) {
+	flags := &EndpointFlags{
+		URL: aws.String(""),
+		ServiceURLs: map[string]*string{
+			"s3":         aws.String("http://localhost:4566"),
+			"cloudwatch": aws.String("http://localhost:4567"),
+		},
+	}
+
+	conf := &aws.Config{}
+	ApplyEndpoints(conf, flags)
+	require.NotNil(t, conf.EndpointResolver)
+	assert.True(t, *conf.S3ForcePathStyle)
+
+	resolved, err := conf.EndpointResolver.EndpointFor("monitoring", "eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:4567", resolved.URL)
+
+	resolved, err = conf.EndpointResolver.EndpointFor("ecs", "eu-west-1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://ecs.eu-west-1.amazonaws.com", resolved.URL)
+}
+
+func TestApplyEndpointsNoOverrides(t *testing.T) {
+	conf := &aws.Config{}
+	ApplyEndpoints(conf, nil)
+	assert.Nil(t, conf.EndpointResolver)
+}
+
+func TestOfflineMode(t *testing.T) {
+	defer func() { offlineMode = false }()
+
+	HandleEndpointFlags(&EndpointFlags{URL: aws.String("http://localhost:4566"), Offline: aws.Bool(true)})
+	require.True(t, offlineMode)
+
+	restore := setRegionEnv(map[string]string{
+		"AWS_CONFIG_FILE":                   "/nonexistent",
+		"AWS_SHARED_CREDENTIALS_FILE":       "/nonexistent",
+		"AWS_EC2_METADATA_SERVICE_ENDPOINT": "http://127.0.0.1:1",
+	})
+	region, source := lookupRegion()
+	restore()
+	assert.Equal(t, OfflineRegion, region)
+	assert.Equal(t, RegionSourceOffline, source)
+
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	conf := &aws.Config{}
+	applyOffline(conf)
+	creds, err := conf.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "test", creds.AccessKeyID)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	conf = &aws.Config{}
+	applyOffline(conf)
+	creds, err = conf.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "id", creds.AccessKeyID)
+
+	offlineMode = false
+	conf = &aws.Config{}
+	applyOffline(conf)
+	assert.Nil(t, conf.Credentials)
+}
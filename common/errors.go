@@ -0,0 +1,188 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type ErrorKind string
+
+const (
+	ErrorKindUnknown    ErrorKind = "unknown"
+	ErrorKindValidation ErrorKind = "validation"
+	ErrorKindAuth       ErrorKind = "auth"
+	ErrorKindNotFound   ErrorKind = "not-found"
+	ErrorKindThrottling ErrorKind = "throttling"
+	ErrorKindNetwork    ErrorKind = "network"
+)
+
+// ExitCodes are the exit codes of the tools for each kind of error.
+var ExitCodes = map[ErrorKind]int{
+	ErrorKindUnknown:    1,
+	ErrorKindValidation: 2,
+	ErrorKindAuth:       3,
+	ErrorKindNotFound:   4,
+	ErrorKindThrottling: 5,
+	ErrorKindNetwork:    6,
+}
+
+var (
+	authErrorCodes = []string{
+		"AccessDenied",
+		"AccessDeniedException",
+		"AuthFailure",
+		"ExpiredToken",
+		"ExpiredTokenException",
+		"IncompleteSignature",
+		"InvalidClientTokenId",
+		"InvalidSignatureException",
+		"MissingAuthenticationToken",
+		"NoCredentialProviders",
+		"NotAuthorized",
+		"SignatureDoesNotMatch",
+		"UnauthorizedOperation",
+		"UnrecognizedClientException",
+	}
+
+	notFoundErrorCodes = []string{
+		"NoSuchBucket",
+		"NoSuchEntity",
+		"NoSuchKey",
+	}
+
+	validationErrorCodes = []string{
+		request.InvalidParameterErrCode,
+		request.ParamRequiredErrCode,
+		"MissingParameter",
+		"ValidationError",
+		"ValidationException",
+	}
+
+	networkErrorCodes = []string{
+		request.ErrCodeRequestError,
+		request.ErrCodeResponseTimeout,
+		request.CanceledErrorCode,
+	}
+)
+
+// Error is an error classified by kind, with the details of the AWS error if any.
+type Error struct {
+	Kind       ErrorKind `json:"kind"`
+	Code       string    `json:"code,omitempty"`
+	Message    string    `json:"message"`
+	RequestID  string    `json:"request_id,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	Err        error     `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Cause() error {
+	return e.Err
+}
+
+// ClassifyError returns err as an *Error, classifying AWS SDK errors.
+func ClassifyError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	if classified, ok := err.(*Error); ok {
+		return classified
+	}
+
+	res := &Error{
+		Kind:    ErrorKindUnknown,
+		Message: err.Error(),
+		Err:     err,
+	}
+
+	cause := errors.Cause(err)
+	if awsErr, ok := cause.(awserr.Error); ok {
+		res.Code = awsErr.Code()
+		if reqErr, ok := cause.(awserr.RequestFailure); ok {
+			res.RequestID = reqErr.RequestID()
+			res.StatusCode = reqErr.StatusCode()
+		}
+	}
+	res.Kind = classifyKind(cause, res.Code, res.StatusCode)
+	res.ExitCode = ExitCodes[res.Kind]
+	return res
+}
+
+func classifyKind(err error, code string, statusCode int) ErrorKind {
+	switch {
+	case code == "":
+		if _, ok := err.(net.Error); ok {
+			return ErrorKindNetwork
+		}
+		return ErrorKindUnknown
+	case request.IsErrorThrottle(err) || statusCode == http.StatusTooManyRequests:
+		return ErrorKindThrottling
+	case containsString(authErrorCodes, code) || statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrorKindAuth
+	case containsString(notFoundErrorCodes, code) || strings.HasSuffix(code, "NotFound") || strings.HasSuffix(code, "NotFoundException") || statusCode == http.StatusNotFound:
+		return ErrorKindNotFound
+	case containsString(validationErrorCodes, code) || strings.HasPrefix(code, "Invalid"):
+		return ErrorKindValidation
+	case containsString(networkErrorCodes, code):
+		return ErrorKindNetwork
+	}
+	return ErrorKindUnknown
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var errorFormat = "text"
+
+// ExitWithError reports err using --error-format and exits with the code of its kind.
+func ExitWithError(err error) {
+	classified := ClassifyError(err)
+	reportError(os.Stderr, err, classified)
+	Exit(classified.ExitCode)
+}
+
+// reportError writes err to w when errors are not logged, the message is the
+// only output before the tool exits so --log-level cannot hide it.
+func reportError(w io.Writer, err error, classified *Error) {
+	if errorFormat == "json" {
+		data, jsonErr := json.Marshal(map[string]*Error{"error": classified})
+		if jsonErr == nil {
+			fmt.Fprintln(w, string(data))
+			return
+		}
+	}
+
+	if !log.IsLevelEnabled(log.ErrorLevel) {
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	entry := log.NewEntry(log.StandardLogger())
+	if classified.Code != "" {
+		entry = entry.WithFields(log.Fields{
+			"kind": classified.Kind,
+			"code": classified.Code,
+		})
+	}
+	entry.Errorln(err)
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	pkgerrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{errors.New("boom"), ErrorKindUnknown},
+		{awserr.New("AccessDeniedException", "denied", nil), ErrorKindAuth},
+		{awserr.New("ParameterNotFound", "missing", nil), ErrorKindNotFound},
+		{awserr.New("ResourceNotFoundException", "missing", nil), ErrorKindNotFound},
+		{awserr.New("NoSuchKey", "missing", nil), ErrorKindNotFound},
+		{awserr.New("ThrottlingException", "slow down", nil), ErrorKindThrottling},
+		{awserr.New("ValidationException", "invalid", nil), ErrorKindValidation},
+		{awserr.New("InvalidParameterValue", "invalid", nil), ErrorKindValidation},
+		{awserr.New("RequestError", "send request failed", errors.New("dial tcp")), ErrorKindNetwork},
+		{awserr.NewRequestFailure(awserr.New("Forbidden", "forbidden", nil), 403, "abc"), ErrorKindAuth},
+		{awserr.NewRequestFailure(awserr.New("SomethingElse", "", nil), 429, "abc"), ErrorKindThrottling},
+		{pkgerrors.Wrap(awserr.New("NoSuchEntity", "missing", nil), "Failed to get user"), ErrorKindNotFound},
+	}
+
+	for _, testCase := range testCases {
+		classified := ClassifyError(testCase.err)
+		assert.Equal(t, testCase.kind, classified.Kind, testCase.err.Error())
+		assert.Equal(t, ExitCodes[testCase.kind], classified.ExitCode)
+		assert.Equal(t, testCase.err.Error(), classified.Error())
+	}
+}
+
+func TestClassifyErrorRequestFailure(t *testing.T) {
+	err := awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), 403, "request-id")
+	classified := ClassifyError(err)
+
+	assert.Equal(t, "AccessDenied", classified.Code)
+	assert.Equal(t, "request-id", classified.RequestID)
+	assert.Equal(t, 403, classified.StatusCode)
+	assert.Equal(t, classified, ClassifyError(classified))
+}
+
+func TestReportErrorHiddenLogLevel(t *testing.T) {
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+
+	err := errors.New("Failed to describe instances")
+	for _, hidden := range []log.Level{log.FatalLevel, log.PanicLevel} {
+		log.SetLevel(hidden)
+		buf := &bytes.Buffer{}
+		reportError(buf, err, ClassifyError(err))
+		assert.Equal(t, "Failed to describe instances\n", buf.String())
+	}
+
+	log.SetLevel(log.ErrorLevel)
+	buf := &bytes.Buffer{}
+	reportError(buf, err, ClassifyError(err))
+	assert.Empty(t, buf.String())
+}
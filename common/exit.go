@@ -0,0 +1,31 @@
+package common
+
+import (
+	"os"
+	"sync"
+)
+
+var (
+	exitHandlers []func()
+	exitOnce     sync.Once
+)
+
+// RegisterExitHandler adds a handler to run before the tool exits.
+func RegisterExitHandler(handler func()) {
+	exitHandlers = append(exitHandlers, handler)
+}
+
+// RunExitHandlers runs the registered handlers once. Tools defer it in main
+// so the handlers also run when main returns.
+func RunExitHandlers() {
+	exitOnce.Do(func() {
+		for _, handler := range exitHandlers {
+			handler()
+		}
+	})
+}
+
+func Exit(code int) {
+	RunExitHandlers()
+	os.Exit(code)
+}
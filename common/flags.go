@@ -1,14 +1,32 @@
 package common
 
-import kingpin "gopkg.in/alecthomas/kingpin.v2"
+import (
+	"os"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
 
 func HandleFlags() *SessionFlags {
 	sessionFlags := KingpinSessionFlags()
 	infoFlags := KingpinInfoFlags()
 	logFlags := KingpinLogFlags()
+	traceFlags := KingpinTraceFlags()
+	KingpinContextFlags()
+
+	args := os.Args[1:]
+	filename, explicit := ToolConfigFilename()
+	if _, err := os.Stat(filename); err == nil || explicit || contextFromArgs(args) != "" {
+		config, err := LoadToolConfig(filename)
+		FatalOnError(err)
+
+		args, err = ContextArgs(kingpin.CommandLine, config, args)
+		FatalOnError(err)
+	}
 
-	kingpin.Parse()
+	kingpin.MustParse(kingpin.CommandLine.Parse(args))
 	HandleInfoFlags(infoFlags)
 	HandleLogFlags(logFlags)
+	HandleTraceFlags(traceFlags)
+	HandleEndpointFlags(sessionFlags.Endpoints)
 	return sessionFlags
 }
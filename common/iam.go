@@ -23,6 +23,7 @@ type SessionFlags struct {
 	MFASerialNumber *string
 	MFATokenCode    *string
 	Duration        *time.Duration
+	Endpoints       *EndpointFlags
 }
 
 func KingpinSessionFlags() *SessionFlags {
@@ -34,15 +35,14 @@ func KingpinSessionFlags() *SessionFlags {
 		MFASerialNumber: kingpin.Flag("mfa-serial-number", "MFA Serial Number").String(),
 		MFATokenCode:    kingpin.Flag("mfa-token-code", "MFA Token Code").String(),
 		Duration:        kingpin.Flag("session-duration", "Session Duration").Default("1h").Duration(),
+		Endpoints:       KingpinEndpointFlags(),
 	}
 }
 
 func NewConfig(region string) *aws.Config {
-	if region == "" {
-		region = os.Getenv("AWS_REGION")
-	}
-	if region == "" {
-		region = "eu-west-1"
+	region, _, err := ResolveRegion(region)
+	if err != nil {
+		Fatalln(err.Error())
 	}
 
 	for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
@@ -54,17 +54,26 @@ func NewConfig(region string) *aws.Config {
 		}
 	}
 
-	return &aws.Config{Region: aws.String(region)}
+	conf := &aws.Config{Region: aws.String(region)}
+	ApplyEndpoints(conf, nil)
+	applyOffline(conf)
+	return conf
 }
 
 func NewSession(region string) *session.Session {
 	awsConfig := NewConfig(region)
-	return session.New(awsConfig)
+	sess := session.New(awsConfig)
+	if apiTracer != nil {
+		apiTracer.Install(&sess.Handlers)
+	}
+	return sess
 }
 
 type SessionTokenProvider struct {
 	SessionFlags *SessionFlags
 	Session      *session.Session
+
+	expiration time.Time
 }
 
 func (p *SessionTokenProvider) Retrieve() (credentials.Value, error) {
@@ -86,6 +95,7 @@ func (p *SessionTokenProvider) Retrieve() (credentials.Value, error) {
 		TokenCode:    tokenCode,
 	}
 	conf := NewConfig(*p.SessionFlags.Region)
+	ApplyEndpoints(conf, p.SessionFlags.Endpoints)
 	stsClient := sts.New(p.Session, conf)
 	output, err := stsClient.GetSessionToken(input)
 	if err != nil {
@@ -95,6 +105,7 @@ func (p *SessionTokenProvider) Retrieve() (credentials.Value, error) {
 	if output.Credentials == nil {
 		return result, errors.New("Could not get credentials")
 	}
+	p.expiration = aws.TimeValue(output.Credentials.Expiration)
 
 	return credentials.Value{
 		AccessKeyID:     *output.Credentials.AccessKeyId,
@@ -107,16 +118,30 @@ func (p *SessionTokenProvider) IsExpired() bool {
 	return false
 }
 
+// ExpiresAt returns the expiration of the session token once retrieved.
+func (p *SessionTokenProvider) ExpiresAt() time.Time {
+	return p.expiration
+}
+
 func OpenSession(sessionFlags *SessionFlags) (*session.Session, *aws.Config) {
+	sessionConfig := aws.Config{}
+	ApplyEndpoints(&sessionConfig, sessionFlags.Endpoints)
+	applyOffline(&sessionConfig)
+
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Config:                  sessionConfig,
 		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
 		SharedConfigState:       session.SharedConfigEnable,
 	}))
+	if apiTracer != nil {
+		apiTracer.Install(&sess.Handlers)
+	}
 	return sess, AssumeRoleConfig(sessionFlags, sess)
 }
 
 func AssumeRoleConfig(sessionFlags *SessionFlags, sess *session.Session) *aws.Config {
 	conf := NewConfig(*sessionFlags.Region)
+	ApplyEndpoints(conf, sessionFlags.Endpoints)
 	if sessionFlags.RoleArn != nil && *sessionFlags.RoleArn != "" {
 		var creds *credentials.Credentials
 		creds = stscreds.NewCredentials(sess, *sessionFlags.RoleArn, func(p *stscreds.AssumeRoleProvider) {
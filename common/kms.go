@@ -2,11 +2,15 @@ package common
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"golang.org/x/crypto/nacl/secretbox"
 )
@@ -14,96 +18,362 @@ import (
 const (
 	keyLength   = 32
 	nonceLength = 24
+
+	// EnvelopeVersion is the version of the envelopes written by EncryptEnvelope.
+	EnvelopeVersion = 2
+
+	AlgorithmSecretBox = "secretbox"
+	AlgorithmAESGCM    = "AES-256-GCM"
 )
 
+// EnvelopeAlgorithms lists the algorithms supported to encrypt the message of envelopes.
+var EnvelopeAlgorithms = []string{AlgorithmSecretBox, AlgorithmAESGCM}
+
+// payload is the legacy gob encoded envelope.
 type payload struct {
 	Key     []byte
 	Nonce   *[nonceLength]byte
 	Message []byte
 }
 
-func EncryptWithKMSAndSecretBox(kmsClient *kms.KMS, plaintext []byte, keyId string) (string, error) {
-	keySpec := "AES_128"
-	dataKeyInput := kms.GenerateDataKeyInput{KeyId: &keyId, KeySpec: &keySpec}
+// Envelope is a message encrypted with a 256-bit data key, itself encrypted with KMS
+// with the encryption context. It is stored as base64 encoded JSON.
+type Envelope struct {
+	Version           int               `json:"version"`
+	KeyID             string            `json:"key_id"`
+	Algorithm         string            `json:"algorithm"`
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+	Key               []byte            `json:"key"`
+	Nonce             []byte            `json:"nonce"`
+	Message           []byte            `json:"message"`
+}
+
+type EnvelopeOptions struct {
+	// Algorithm defaults to AlgorithmSecretBox.
+	Algorithm         string
+	EncryptionContext map[string]string
+}
+
+// additionalData binds the version and algorithm to AES-GCM messages.
+func (e *Envelope) additionalData() []byte {
+	return []byte(fmt.Sprintf("awstools-envelope-v%d:%s", e.Version, e.Algorithm))
+}
 
-	dataKeyOutput, err := kmsClient.GenerateDataKey(&dataKeyInput)
+func kmsEncryptionContext(context map[string]string) map[string]*string {
+	if len(context) == 0 {
+		return nil
+	}
+	return aws.StringMap(context)
+}
+
+// EncryptEnvelope encrypts plaintext with a new data key from keyID.
+func EncryptEnvelope(kmsClient *kms.KMS, plaintext []byte, keyID string, options *EnvelopeOptions) (string, error) {
+	if options == nil {
+		options = &EnvelopeOptions{}
+	}
+	algorithm := options.Algorithm
+	if algorithm == "" {
+		algorithm = AlgorithmSecretBox
+	}
+
+	dataKeyOutput, err := kmsClient.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             aws.String(keyID),
+		KeySpec:           aws.String("AES_256"),
+		EncryptionContext: kmsEncryptionContext(options.EncryptionContext),
+	})
 	if err != nil {
 		return "", err
 	}
-	// Initialize payload
-	p := &payload{
-		Key:   dataKeyOutput.CiphertextBlob,
-		Nonce: &[nonceLength]byte{},
+
+	e := &Envelope{
+		Version:           EnvelopeVersion,
+		KeyID:             aws.StringValue(dataKeyOutput.KeyId),
+		Algorithm:         algorithm,
+		EncryptionContext: options.EncryptionContext,
+		Key:               dataKeyOutput.CiphertextBlob,
+	}
+	err = e.seal(dataKeyOutput.Plaintext, plaintext)
+	if err != nil {
+		return "", err
 	}
 
-	// Set nonce
-	_, err = rand.Read(p.Nonce[:])
+	data, err := json.Marshal(e)
 	if err != nil {
 		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
 
-	// Create key
-	key := &[keyLength]byte{}
-	copy(key[:], dataKeyOutput.Plaintext)
+func (e *Envelope) seal(dataKey, plaintext []byte) error {
+	if len(dataKey) != keyLength {
+		return fmt.Errorf("Invalid data key length %d", len(dataKey))
+	}
+
+	switch e.Algorithm {
+	case AlgorithmSecretBox:
+		nonce := &[nonceLength]byte{}
+		_, err := rand.Read(nonce[:])
+		if err != nil {
+			return err
+		}
+		key := &[keyLength]byte{}
+		copy(key[:], dataKey)
+
+		e.Nonce = nonce[:]
+		e.Message = secretbox.Seal(nil, plaintext, nonce, key)
+	case AlgorithmAESGCM:
+		aead, err := newGCM(dataKey)
+		if err != nil {
+			return err
+		}
+		e.Nonce = make([]byte, aead.NonceSize())
+		_, err = rand.Read(e.Nonce)
+		if err != nil {
+			return err
+		}
+		e.Message = aead.Seal(nil, e.Nonce, plaintext, e.additionalData())
+	default:
+		return fmt.Errorf("Unknown envelope algorithm %s", e.Algorithm)
+	}
+	return nil
+}
 
-	// Encrypt message
-	p.Message = secretbox.Seal(p.Message, plaintext, p.Nonce, key)
+func (e *Envelope) open(dataKey []byte) ([]byte, error) {
+	if len(dataKey) != keyLength {
+		return nil, fmt.Errorf("Invalid data key length %d", len(dataKey))
+	}
+
+	switch e.Algorithm {
+	case AlgorithmSecretBox:
+		if len(e.Nonce) != nonceLength {
+			return nil, fmt.Errorf("Invalid nonce length %d", len(e.Nonce))
+		}
+		nonce := &[nonceLength]byte{}
+		copy(nonce[:], e.Nonce)
+		key := &[keyLength]byte{}
+		copy(key[:], dataKey)
+
+		plaintext, ok := secretbox.Open(nil, e.Message, nonce, key)
+		if !ok {
+			return nil, fmt.Errorf("Failed to open secretbox")
+		}
+		return plaintext, nil
+	case AlgorithmAESGCM:
+		aead, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(e.Nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("Invalid nonce length %d", len(e.Nonce))
+		}
+		return aead.Open(nil, e.Nonce, e.Message, e.additionalData())
+	default:
+		return nil, fmt.Errorf("Unknown envelope algorithm %s", e.Algorithm)
+	}
+}
 
-	buf := &bytes.Buffer{}
-	err = gob.NewEncoder(buf).Encode(p)
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptWithKMSAndSecretBox encrypts plaintext in an envelope using secretbox.
+//
+// Deprecated: use EncryptEnvelope to set the algorithm and encryption context.
+func EncryptWithKMSAndSecretBox(kmsClient *kms.KMS, plaintext []byte, keyId string) (string, error) {
+	return EncryptEnvelope(kmsClient, plaintext, keyId, nil)
+}
+
+// ParseEnvelope decodes an envelope, it returns nil if ciphertext is a legacy
+// gob envelope or a KMS ciphertext blob.
+func ParseEnvelope(ciphertext string) (*Envelope, error) {
+	content, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnvelope(content)
+}
+
+func parseEnvelope(content []byte) (*Envelope, error) {
+	if len(content) == 0 || content[0] != '{' {
+		return nil, nil
+	}
+
+	e := &Envelope{}
+	err := json.Unmarshal(content, e)
+	if err != nil {
+		return nil, err
+	}
+	if e.Version != EnvelopeVersion {
+		return nil, fmt.Errorf("Unsupported envelope version %d", e.Version)
+	}
+	return e, nil
+}
+
+// isLegacyPayload looks for the name of the gob type in the header, its offset
+// depends on the size of the type id.
+func isLegacyPayload(content []byte) bool {
+	header := content
+	if len(header) > 16 {
+		header = header[:16]
 	}
-	output := base64.StdEncoding.EncodeToString(buf.Bytes())
-	return output, nil
+	return bytes.Contains(header, []byte("\x07payload"))
 }
 
+// DecryptWithKMS decrypts envelopes, legacy gob envelopes and KMS ciphertext blobs.
+// The encryption context of envelopes is read from the envelope.
 func DecryptWithKMS(kmsClient *kms.KMS, ciphertext string) ([]byte, error) {
-	buf := make([]byte, base64.StdEncoding.DecodedLen(len(ciphertext)))
-	l, err := base64.StdEncoding.Decode(buf, []byte(ciphertext))
+	return DecryptWithKMSContext(kmsClient, ciphertext, nil)
+}
+
+// DecryptWithKMSContext decrypts like DecryptWithKMS and fails if the encryption
+// context of an envelope is not encryptionContext. It is used as is for KMS ciphertext blobs.
+func DecryptWithKMSContext(kmsClient *kms.KMS, ciphertext string, encryptionContext map[string]string) ([]byte, error) {
+	content, err := base64.StdEncoding.DecodeString(ciphertext)
 	if err != nil {
 		return nil, err
 	}
-	content := buf[:l]
 
-	usingSecretBox := false
-	if len(content) > 14 {
-		if content[6] == 0x07 {
-			if bytes.Compare(content[7:14], []byte("payload")) == 0 {
-				usingSecretBox = true
-			}
-		}
+	e, err := parseEnvelope(content)
+	if err != nil {
+		return nil, err
+	}
+	if e != nil {
+		return decryptEnvelope(kmsClient, e, encryptionContext)
 	}
 
-	var p payload
+	if isLegacyPayload(content) {
+		return decryptLegacyPayload(kmsClient, content)
+	}
 
-	if usingSecretBox {
-		err = gob.NewDecoder(bytes.NewReader(content)).Decode(&p)
-		if err != nil {
-			return nil, err
+	dataKeyOutput, err := kmsClient.Decrypt(&kms.DecryptInput{
+		CiphertextBlob:    content,
+		EncryptionContext: kmsEncryptionContext(encryptionContext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataKeyOutput.Plaintext, nil
+}
+
+func sameEncryptionContext(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
 		}
-		content = p.Key
 	}
-	// fmt.Println(p)
+	return true
+}
+
+func decryptEnvelope(kmsClient *kms.KMS, e *Envelope, expectedContext map[string]string) ([]byte, error) {
+	if expectedContext != nil && !sameEncryptionContext(expectedContext, e.EncryptionContext) {
+		return nil, fmt.Errorf("Envelope encryption context does not match")
+	}
+
 	dataKeyOutput, err := kmsClient.Decrypt(&kms.DecryptInput{
-		CiphertextBlob: content,
+		CiphertextBlob:    e.Key,
+		EncryptionContext: kmsEncryptionContext(e.EncryptionContext),
 	})
 	if err != nil {
 		return nil, err
 	}
+	return e.open(dataKeyOutput.Plaintext)
+}
 
-	if usingSecretBox {
-		key := &[keyLength]byte{}
-		copy(key[:], dataKeyOutput.Plaintext)
+// decryptLegacyPayload decrypts gob envelopes using AES_128 data keys zero padded to 32 bytes.
+func decryptLegacyPayload(kmsClient *kms.KMS, content []byte) ([]byte, error) {
+	var p payload
+	err := gob.NewDecoder(bytes.NewReader(content)).Decode(&p)
+	if err != nil {
+		return nil, err
+	}
 
-		// Decrypt message
-		var plaintext []byte
-		plaintext, ok := secretbox.Open(plaintext, p.Message, p.Nonce, key)
-		if !ok {
-			return nil, fmt.Errorf("Failed to open secretbox")
+	dataKeyOutput, err := kmsClient.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: p.Key,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	key := &[keyLength]byte{}
+	copy(key[:], dataKeyOutput.Plaintext)
+
+	plaintext, ok := secretbox.Open(nil, p.Message, p.Nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("Failed to open secretbox")
+	}
+	return plaintext, nil
+}
+
+// ReEncryptWithKMS re-encrypts ciphertext under targetKeyID without decrypting
+// the message, only the data key of envelopes is re-encrypted. It returns the
+// new ciphertext in the same format and the key id it was encrypted with.
+func ReEncryptWithKMS(kmsClient *kms.KMS, ciphertext string, targetKeyID string) (string, string, error) {
+	content, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", "", err
+	}
+
+	e, err := parseEnvelope(content)
+	if err != nil {
+		return "", "", err
+	}
+	if e != nil {
+		res, err := kmsClient.ReEncrypt(&kms.ReEncryptInput{
+			CiphertextBlob:               e.Key,
+			SourceEncryptionContext:      kmsEncryptionContext(e.EncryptionContext),
+			DestinationKeyId:             aws.String(targetKeyID),
+			DestinationEncryptionContext: kmsEncryptionContext(e.EncryptionContext),
+		})
+		if err != nil {
+			return "", "", err
 		}
-		return plaintext, nil
+		e.Key = res.CiphertextBlob
+		e.KeyID = aws.StringValue(res.KeyId)
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return "", "", err
+		}
+		return base64.StdEncoding.EncodeToString(data), aws.StringValue(res.SourceKeyId), nil
 	}
 
-	return dataKeyOutput.Plaintext, nil
+	if isLegacyPayload(content) {
+		var p payload
+		err = gob.NewDecoder(bytes.NewReader(content)).Decode(&p)
+		if err != nil {
+			return "", "", err
+		}
+
+		res, err := kmsClient.ReEncrypt(&kms.ReEncryptInput{
+			CiphertextBlob:   p.Key,
+			DestinationKeyId: aws.String(targetKeyID),
+		})
+		if err != nil {
+			return "", "", err
+		}
+		p.Key = res.CiphertextBlob
+
+		buf := &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(&p)
+		if err != nil {
+			return "", "", err
+		}
+		return base64.StdEncoding.EncodeToString(buf.Bytes()), aws.StringValue(res.SourceKeyId), nil
+	}
+
+	res, err := kmsClient.ReEncrypt(&kms.ReEncryptInput{
+		CiphertextBlob:   content,
+		DestinationKeyId: aws.String(targetKeyID),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(res.CiphertextBlob), aws.StringValue(res.SourceKeyId), nil
 }
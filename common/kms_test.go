@@ -0,0 +1,230 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// fakeKMS "encrypts" by wrapping the plaintext and the encryption context in JSON.
+type fakeKMS struct{}
+
+type fakeKMSBlob struct {
+	KeyID     string
+	Plaintext []byte
+	Context   map[string]string
+}
+
+type fakeKMSInput struct {
+	KeyId                        string
+	KeySpec                      string
+	Plaintext                    []byte
+	CiphertextBlob               []byte
+	EncryptionContext            map[string]string
+	SourceEncryptionContext      map[string]string
+	DestinationKeyId             string
+	DestinationEncryptionContext map[string]string
+}
+
+func fakeKMSKeyARN(keyID string) string {
+	return "arn:aws:kms:eu-west-1:123456789012:key/" + keyID
+}
+
+func fakeKMSWrap(keyID string, plaintext []byte, context map[string]string) []byte {
+	data, _ := json.Marshal(fakeKMSBlob{KeyID: fakeKMSKeyARN(keyID), Plaintext: plaintext, Context: context})
+	return append([]byte("blob:"), data...)
+}
+
+func fakeKMSUnwrap(blob []byte) fakeKMSBlob {
+	res := fakeKMSBlob{}
+	json.Unmarshal(bytes.TrimPrefix(blob, []byte("blob:")), &res)
+	return res
+}
+
+func (f fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.")
+	input := fakeKMSInput{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	output := map[string]interface{}{}
+	switch operation {
+	case "GenerateDataKey":
+		size := 32
+		if input.KeySpec == "AES_128" {
+			size = 16
+		}
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		output["KeyId"] = fakeKMSKeyARN(input.KeyId)
+		output["Plaintext"] = plaintext
+		output["CiphertextBlob"] = fakeKMSWrap(input.KeyId, plaintext, input.EncryptionContext)
+	case "Encrypt":
+		output["CiphertextBlob"] = fakeKMSWrap(input.KeyId, input.Plaintext, input.EncryptionContext)
+	case "Decrypt":
+		blob := fakeKMSUnwrap(input.CiphertextBlob)
+		if !sameEncryptionContext(blob.Context, input.EncryptionContext) {
+			fakeKMSError(w)
+			return
+		}
+		output["Plaintext"] = blob.Plaintext
+	case "ReEncrypt":
+		blob := fakeKMSUnwrap(input.CiphertextBlob)
+		if !sameEncryptionContext(blob.Context, input.SourceEncryptionContext) {
+			fakeKMSError(w)
+			return
+		}
+		output["SourceKeyId"] = blob.KeyID
+		output["KeyId"] = fakeKMSKeyARN(input.DestinationKeyId)
+		output["CiphertextBlob"] = fakeKMSWrap(input.DestinationKeyId, blob.Plaintext, input.DestinationEncryptionContext)
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(output)
+}
+
+func fakeKMSError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprint(w, `{"__type": "InvalidCiphertextException", "message": "invalid context"}`)
+}
+
+func newFakeKMSClient(t *testing.T) (*kms.KMS, *httptest.Server) {
+	server := httptest.NewServer(fakeKMS{})
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+	return kms.New(sess), server
+}
+
+func TestEnvelopeEncryptDecrypt(t *testing.T) {
+	kmsClient, server := newFakeKMSClient(t)
+	defer server.Close()
+
+	context := map[string]string{"app": "test"}
+	for _, algorithm := range EnvelopeAlgorithms {
+		ciphertext, err := EncryptEnvelope(kmsClient, []byte("value"), "key-id", &EnvelopeOptions{
+			Algorithm:         algorithm,
+			EncryptionContext: context,
+		})
+		require.NoError(t, err, algorithm)
+
+		e, err := ParseEnvelope(ciphertext)
+		require.NoError(t, err)
+		assert.Equal(t, EnvelopeVersion, e.Version)
+		assert.Equal(t, algorithm, e.Algorithm)
+		assert.Equal(t, fakeKMSKeyARN("key-id"), e.KeyID)
+		assert.Equal(t, context, e.EncryptionContext)
+
+		plaintext, err := DecryptWithKMS(kmsClient, ciphertext)
+		require.NoError(t, err, algorithm)
+		assert.Equal(t, []byte("value"), plaintext)
+
+		plaintext, err = DecryptWithKMSContext(kmsClient, ciphertext, context)
+		require.NoError(t, err, algorithm)
+		assert.Equal(t, []byte("value"), plaintext)
+
+		_, err = DecryptWithKMSContext(kmsClient, ciphertext, map[string]string{"app": "other"})
+		assert.Error(t, err, algorithm)
+	}
+
+	_, err := EncryptEnvelope(kmsClient, []byte("value"), "key-id", &EnvelopeOptions{Algorithm: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestEnvelopeTampered(t *testing.T) {
+	kmsClient, server := newFakeKMSClient(t)
+	defer server.Close()
+
+	ciphertext, err := EncryptEnvelope(kmsClient, []byte("value"), "key-id", &EnvelopeOptions{Algorithm: AlgorithmAESGCM})
+	require.NoError(t, err)
+
+	e, err := ParseEnvelope(ciphertext)
+	require.NoError(t, err)
+	e.Algorithm = AlgorithmSecretBox
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	_, err = DecryptWithKMS(kmsClient, base64.StdEncoding.EncodeToString(data))
+	assert.Error(t, err)
+}
+
+func TestDecryptLegacyFormats(t *testing.T) {
+	kmsClient, server := newFakeKMSClient(t)
+	defer server.Close()
+
+	// KMS ciphertext blob
+	res, err := kmsClient.Encrypt(&kms.EncryptInput{KeyId: aws.String("key-id"), Plaintext: []byte("value")})
+	require.NoError(t, err)
+	plaintext, err := DecryptWithKMS(kmsClient, base64.StdEncoding.EncodeToString(res.CiphertextBlob))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), plaintext)
+
+	plaintext, err = DecryptWithKMS(kmsClient, newLegacyPayload(t, kmsClient, "key-id"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("value"), plaintext)
+}
+
+// newLegacyPayload returns a gob envelope with an AES_128 data key.
+func newLegacyPayload(t *testing.T, kmsClient *kms.KMS, keyID string) string {
+	dataKey, err := kmsClient.GenerateDataKey(&kms.GenerateDataKeyInput{KeyId: aws.String(keyID), KeySpec: aws.String("AES_128")})
+	require.NoError(t, err)
+	p := &payload{Key: dataKey.CiphertextBlob, Nonce: &[nonceLength]byte{}}
+	_, err = rand.Read(p.Nonce[:])
+	require.NoError(t, err)
+	key := &[keyLength]byte{}
+	copy(key[:], dataKey.Plaintext)
+	p.Message = secretbox.Seal(nil, []byte("value"), p.Nonce, key)
+	buf := &bytes.Buffer{}
+	require.NoError(t, gob.NewEncoder(buf).Encode(p))
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestReEncryptWithKMS(t *testing.T) {
+	kmsClient, server := newFakeKMSClient(t)
+	defer server.Close()
+
+	context := map[string]string{"app": "test"}
+	envelope, err := EncryptEnvelope(kmsClient, []byte("value"), "old", &EnvelopeOptions{EncryptionContext: context})
+	require.NoError(t, err)
+
+	res, err := kmsClient.Encrypt(&kms.EncryptInput{KeyId: aws.String("old"), Plaintext: []byte("value")})
+	require.NoError(t, err)
+	blob := base64.StdEncoding.EncodeToString(res.CiphertextBlob)
+
+	for _, ciphertext := range []string{envelope, blob, newLegacyPayload(t, kmsClient, "old")} {
+		reencrypted, sourceKeyID, err := ReEncryptWithKMS(kmsClient, ciphertext, "new")
+		require.NoError(t, err)
+		assert.Equal(t, fakeKMSKeyARN("old"), sourceKeyID)
+		assert.NotEqual(t, ciphertext, reencrypted)
+
+		plaintext, err := DecryptWithKMS(kmsClient, reencrypted)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("value"), plaintext)
+	}
+
+	reencrypted, _, err := ReEncryptWithKMS(kmsClient, envelope, "new")
+	require.NoError(t, err)
+	e, err := ParseEnvelope(reencrypted)
+	require.NoError(t, err)
+	assert.Equal(t, fakeKMSKeyARN("new"), e.KeyID)
+	assert.Equal(t, context, e.EncryptionContext)
+}
@@ -6,11 +6,16 @@ import (
 )
 
 type LogFlags struct {
-	LogLevel  *string
-	LogFormat *string
+	LogLevel    *string
+	LogFormat   *string
+	ErrorFormat *string
 }
 
 func HandleLogFlags(flags *LogFlags) {
+	if flags.ErrorFormat != nil {
+		errorFormat = *flags.ErrorFormat
+	}
+
 	switch *flags.LogFormat {
 	case "json":
 		log.SetFormatter(&log.JSONFormatter{})
@@ -43,7 +48,8 @@ func HandleLogFlags(flags *LogFlags) {
 func KingpinLogFlags() *LogFlags {
 
 	return &LogFlags{
-		LogLevel:  kingpin.Flag("log-level", "Log level").Default("warn").Enum("trace", "debug", "info", "warn", "error", "fatal", "panic"),
-		LogFormat: kingpin.Flag("log-format", "Log format").Default("text").Enum("text", "json"),
+		LogLevel:    kingpin.Flag("log-level", "Log level").Default("warn").Enum("trace", "debug", "info", "warn", "error", "fatal", "panic"),
+		LogFormat:   kingpin.Flag("log-format", "Log format").Default("text").Enum("text", "json"),
+		ErrorFormat: kingpin.Flag("error-format", "Format of fatal errors printed to stderr").Default("text").Enum("text", "json"),
 	}
 }
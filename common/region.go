@@ -0,0 +1,100 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RegionSourceFlag         = "flag"
+	RegionSourceEnv          = "env"
+	RegionSourceSharedConfig = "shared-config"
+	RegionSourceIMDS         = "instance-metadata"
+	RegionSourceOffline      = "offline"
+)
+
+var (
+	ErrRegionNotFound = errors.New("Could not resolve the AWS region, use --region or set AWS_REGION")
+
+	resolvedRegion       string
+	resolvedRegionSource string
+	resolvedRegionM      sync.Mutex
+)
+
+// ResolveRegion returns the region to use and where it was found. An empty
+// region is looked up from AWS_REGION, AWS_DEFAULT_REGION, the shared config
+// of the current profile and finally the instance metadata service, which is
+// replaced by OfflineRegion with --offline.
+func ResolveRegion(region string) (string, string, error) {
+	if region != "" {
+		logRegion(region, RegionSourceFlag)
+		return region, RegionSourceFlag, nil
+	}
+
+	resolvedRegionM.Lock()
+	defer resolvedRegionM.Unlock()
+
+	if resolvedRegion != "" {
+		return resolvedRegion, resolvedRegionSource, nil
+	}
+
+	region, source := lookupRegion()
+	if region == "" {
+		return "", "", ErrRegionNotFound
+	}
+
+	logRegion(region, source)
+	resolvedRegion = region
+	resolvedRegionSource = source
+	return region, source, nil
+}
+
+func logRegion(region, source string) {
+	log.WithFields(log.Fields{
+		"region": region,
+		"source": source,
+	}).Debug("Resolved AWS region")
+}
+
+func lookupRegion() (string, string) {
+	for _, key := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if value := os.Getenv(key); value != "" {
+			return value, RegionSourceEnv
+		}
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		log.WithError(err).Debug("Failed to load the shared config")
+		return "", ""
+	}
+	if sess.Config.Region != nil && *sess.Config.Region != "" {
+		return *sess.Config.Region, RegionSourceSharedConfig
+	}
+
+	if offlineMode {
+		return OfflineRegion, RegionSourceOffline
+	}
+
+	// the client uses IMDSv2 tokens and honours AWS_EC2_METADATA_DISABLED
+	client := ec2metadata.New(sess, &aws.Config{
+		HTTPClient: &http.Client{Timeout: time.Second},
+		MaxRetries: aws.Int(0),
+	})
+	region, err := client.Region()
+	if err != nil {
+		log.WithError(err).Debug("Failed to get the region from the instance metadata")
+		return "", ""
+	}
+	return region, RegionSourceIMDS
+}
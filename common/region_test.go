@@ -0,0 +1,129 @@
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var regionEnvKeys = []string{
+	"AWS_REGION",
+	"AWS_DEFAULT_REGION",
+	"AWS_PROFILE",
+	"AWS_CONFIG_FILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_EC2_METADATA_DISABLED",
+	"AWS_EC2_METADATA_SERVICE_ENDPOINT",
+}
+
+// setRegionEnv replaces the variables used to look up the region and
+// returns a function restoring them.
+func setRegionEnv(env map[string]string) func() {
+	previous := map[string]*string{}
+	for _, key := range regionEnvKeys {
+		if value, ok := os.LookupEnv(key); ok {
+			previous[key] = &value
+		} else {
+			previous[key] = nil
+		}
+		os.Unsetenv(key)
+		if value, ok := env[key]; ok {
+			os.Setenv(key, value)
+		}
+	}
+
+	return func() {
+		for key, value := range previous {
+			if value == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *value)
+			}
+		}
+	}
+}
+
+// newFakeIMDS serves the IMDSv2 token and an identity document in region.
+func newFakeIMDS(region string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			w.Write([]byte("token"))
+		case "/latest/dynamic/instance-identity/document":
+			w.Write([]byte(`{"region": "` + region + `"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestLookupRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "region")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, ioutil.WriteFile(configFile, []byte("[default]\nregion = eu-west-2\n\n[profile other]\nregion = ap-south-1\n"), 0600))
+	credentialsFile := filepath.Join(dir, "credentials")
+
+	imds := newFakeIMDS("us-west-2")
+	defer imds.Close()
+
+	testCases := []struct {
+		name   string
+		env    map[string]string
+		region string
+		source string
+	}{
+		{"region", map[string]string{"AWS_REGION": "eu-west-1", "AWS_DEFAULT_REGION": "us-east-1"}, "eu-west-1", RegionSourceEnv},
+		{"default region", map[string]string{"AWS_DEFAULT_REGION": "us-east-1", "AWS_CONFIG_FILE": configFile}, "us-east-1", RegionSourceEnv},
+		{"shared config", map[string]string{"AWS_CONFIG_FILE": configFile}, "eu-west-2", RegionSourceSharedConfig},
+		{"profile", map[string]string{"AWS_CONFIG_FILE": configFile, "AWS_PROFILE": "other"}, "ap-south-1", RegionSourceSharedConfig},
+		{"instance metadata", map[string]string{"AWS_EC2_METADATA_SERVICE_ENDPOINT": imds.URL}, "us-west-2", RegionSourceIMDS},
+		{"instance metadata disabled", map[string]string{"AWS_EC2_METADATA_SERVICE_ENDPOINT": imds.URL, "AWS_EC2_METADATA_DISABLED": "true"}, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		env := map[string]string{
+			"AWS_CONFIG_FILE":                   filepath.Join(dir, "missing"),
+			"AWS_SHARED_CREDENTIALS_FILE":       credentialsFile,
+			"AWS_EC2_METADATA_SERVICE_ENDPOINT": "http://127.0.0.1:1",
+		}
+		for key, value := range testCase.env {
+			env[key] = value
+		}
+
+		restore := setRegionEnv(env)
+		region, source := lookupRegion()
+		restore()
+
+		assert.Equal(t, testCase.region, region, testCase.name)
+		assert.Equal(t, testCase.source, source, testCase.name)
+	}
+}
+
+func TestResolveRegionLogsSource(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	log.SetLevel(log.DebugLevel)
+
+	region, source, err := ResolveRegion("eu-central-1")
+	require.NoError(t, err)
+	assert.Equal(t, "eu-central-1", region)
+	assert.Equal(t, RegionSourceFlag, source)
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, "Resolved AWS region", entry.Message)
+	assert.Equal(t, RegionSourceFlag, entry.Data["source"])
+}
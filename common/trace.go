@@ -0,0 +1,158 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	log "github.com/sirupsen/logrus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+type TraceFlags struct {
+	TraceAPI *bool
+}
+
+func KingpinTraceFlags() *TraceFlags {
+	return &TraceFlags{
+		TraceAPI: kingpin.Flag("trace-api", "Log every AWS API call and print a summary on exit").Default("false").Bool(),
+	}
+}
+
+var apiTracer *APITracer
+
+// HandleTraceFlags must run after HandleLogFlags to use the same log format.
+func HandleTraceFlags(flags *TraceFlags) {
+	if !*flags.TraceAPI {
+		return
+	}
+
+	apiTracer = NewAPITracer()
+	RegisterExitHandler(apiTracer.PrintSummary)
+}
+
+type APICallStats struct {
+	Service   string
+	Operation string
+	Calls     int
+	Retries   int
+	Errors    int
+	Duration  time.Duration
+}
+
+// APITracer logs AWS API calls and counts them per operation.
+type APITracer struct {
+	Logger *log.Logger
+	Stats  map[string]*APICallStats
+	m      sync.Mutex
+}
+
+func NewAPITracer() *APITracer {
+	logger := log.New()
+	logger.Out = os.Stderr
+	logger.Formatter = log.StandardLogger().Formatter
+	logger.Level = log.InfoLevel
+
+	return &APITracer{
+		Logger: logger,
+		Stats:  map[string]*APICallStats{},
+	}
+}
+
+// Install adds the tracer to the handlers of a session or client.
+func (t *APITracer) Install(handlers *request.Handlers) {
+	handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "awstools.APITracer",
+		Fn:   t.handleComplete,
+	})
+}
+
+func (t *APITracer) handleComplete(r *request.Request) {
+	duration := time.Since(r.Time)
+
+	fields := log.Fields{
+		"service":     r.ClientInfo.ServiceName,
+		"operation":   r.Operation.Name,
+		"region":      aws.StringValue(r.Config.Region),
+		"duration":    duration.String(),
+		"retry_count": r.RetryCount,
+		"request_id":  r.RequestID,
+	}
+	if r.Error != nil {
+		if awsErr, ok := r.Error.(awserr.Error); ok {
+			fields["error_code"] = awsErr.Code()
+		} else {
+			fields["error_code"] = r.Error.Error()
+		}
+	}
+	t.Logger.WithFields(fields).Info("AWS API call")
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	key := fmt.Sprintf("%s:%s", r.ClientInfo.ServiceName, r.Operation.Name)
+	stats, ok := t.Stats[key]
+	if !ok {
+		stats = &APICallStats{
+			Service:   r.ClientInfo.ServiceName,
+			Operation: r.Operation.Name,
+		}
+		t.Stats[key] = stats
+	}
+	stats.Calls++
+	stats.Retries += r.RetryCount
+	stats.Duration += duration
+	if r.Error != nil {
+		stats.Errors++
+	}
+}
+
+func (t *APITracer) sortedStats() []*APICallStats {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	res := make([]*APICallStats, 0, len(t.Stats))
+	for _, stats := range t.Stats {
+		res = append(res, stats)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Service == res[j].Service {
+			return res[i].Operation < res[j].Operation
+		}
+		return res[i].Service < res[j].Service
+	})
+	return res
+}
+
+// PrintSummary prints the call counts per operation to the output of the
+// logger, stderr by default, or logs them when using --log-format json.
+func (t *APITracer) PrintSummary() {
+	stats := t.sortedStats()
+
+	if _, ok := t.Logger.Formatter.(*log.JSONFormatter); ok {
+		for _, s := range stats {
+			t.Logger.WithFields(log.Fields{
+				"service":   s.Service,
+				"operation": s.Operation,
+				"calls":     s.Calls,
+				"retries":   s.Retries,
+				"errors":    s.Errors,
+				"duration":  s.Duration.String(),
+			}).Info("AWS API calls summary")
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(t.Logger.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tOPERATION\tCALLS\tRETRIES\tERRORS\tDURATION")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\n", s.Service, s.Operation, s.Calls, s.Retries, s.Errors, s.Duration)
+	}
+	w.Flush()
+}
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITracer(t *testing.T) {
+	throttled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Header().Set("X-Amzn-Requestid", "request-id")
+		name := ""
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if value, ok := input["Name"].(string); ok {
+			name = value
+		}
+
+		switch {
+		case name == "/throttled" && !throttled:
+			throttled = true
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ThrottlingException", "message": "slow down"}`))
+		case name == "/missing":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type": "ParameterNotFound", "message": "not found"}`))
+		default:
+			w.Write([]byte(`{"Parameter": {"Name": "` + name + `", "Value": "value"}}`))
+		}
+	}))
+	defer server.Close()
+
+	tracer := NewAPITracer()
+	out := &bytes.Buffer{}
+	tracer.Logger.Out = out
+	tracer.Logger.Formatter = &log.JSONFormatter{}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	tracer.Install(&sess.Handlers)
+	client := ssm.New(sess)
+
+	for _, name := range []string{"/a", "/throttled", "/missing"} {
+		client.GetParameter(&ssm.GetParameterInput{Name: aws.String(name)})
+	}
+	client.DescribeParameters(&ssm.DescribeParametersInput{})
+
+	calls := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		entry := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		calls = append(calls, entry)
+	}
+	require.Len(t, calls, 4)
+	assert.Equal(t, "AWS API call", calls[0]["msg"])
+	assert.Equal(t, "ssm", calls[0]["service"])
+	assert.Equal(t, "GetParameter", calls[0]["operation"])
+	assert.Equal(t, "eu-west-1", calls[0]["region"])
+	assert.Equal(t, "request-id", calls[0]["request_id"])
+	assert.Equal(t, float64(1), calls[1]["retry_count"])
+	assert.Equal(t, "ParameterNotFound", calls[2]["error_code"])
+	assert.Equal(t, "DescribeParameters", calls[3]["operation"])
+
+	stats := tracer.sortedStats()
+	require.Len(t, stats, 2)
+	assert.Equal(t, "DescribeParameters", stats[0].Operation)
+	assert.Equal(t, 1, stats[0].Calls)
+	assert.Equal(t, "GetParameter", stats[1].Operation)
+	assert.Equal(t, 3, stats[1].Calls)
+	assert.Equal(t, 1, stats[1].Retries)
+	assert.Equal(t, 1, stats[1].Errors)
+
+	out.Reset()
+	tracer.PrintSummary()
+	assert.Contains(t, out.String(), `"calls":3`)
+	assert.Contains(t, out.String(), `"msg":"AWS API calls summary"`)
+
+	out.Reset()
+	tracer.Logger.Formatter = &log.TextFormatter{}
+	tracer.PrintSummary()
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, []string{"SERVICE", "OPERATION", "CALLS", "RETRIES", "ERRORS", "DURATION"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"ssm", "DescribeParameters", "1", "0", "0"}, strings.Fields(lines[1])[:5])
+	assert.Equal(t, []string{"ssm", "GetParameter", "3", "1", "1"}, strings.Fields(lines[2])[:5])
+}
+
+func TestHandleTraceFlags(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"Parameters": []}`))
+	}))
+	defer server.Close()
+
+	defer func(handlers []func()) {
+		exitHandlers = handlers
+		apiTracer = nil
+	}(exitHandlers)
+
+	HandleTraceFlags(&TraceFlags{TraceAPI: aws.Bool(false)})
+	assert.Nil(t, apiTracer)
+
+	handlers := len(exitHandlers)
+	HandleTraceFlags(&TraceFlags{TraceAPI: aws.Bool(true)})
+	require.NotNil(t, apiTracer)
+	assert.Len(t, exitHandlers, handlers+1)
+	apiTracer.Logger.Out = &bytes.Buffer{}
+
+	os.Setenv("AWS_ENDPOINT_URL", server.URL)
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ENDPOINT_URL")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	_, err := ssm.New(NewSession("eu-west-1")).DescribeParameters(&ssm.DescribeParametersInput{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, apiTracer.Stats["ssm:DescribeParameters"].Calls)
+}
@@ -1,27 +1,28 @@
 package common
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"os"
 
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 )
 
 func FatalOnError(err error) {
 	if err != nil {
-		log.Fatalln(err)
+		ExitWithError(err)
 	}
 }
 
 func FatalOnErrorW(err error, msg string) {
 	if err != nil {
-		log.Fatalln(errors.Wrap(err, msg))
+		ExitWithError(errors.Wrap(err, msg))
 	}
 }
 
 func Fatalln(message string) {
-	log.Fatalln(message)
+	ExitWithError(errors.New(message))
 }
 
 func LoadJSON(filename string, res interface{}) error {
@@ -32,3 +33,23 @@ func LoadJSON(filename string, res interface{}) error {
 
 	return json.Unmarshal(bytes, res)
 }
+
+// unmarshalJSONNumbers decodes numbers as json.Number to keep the precision
+// of large integers like account ids.
+func unmarshalJSONNumbers(data []byte, res interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(res)
+}
+
+// ReadInput returns value if set, otherwise the content of filename, or stdin
+// when filename is empty or -.
+func ReadInput(value, filename string) ([]byte, error) {
+	if value != "" {
+		return []byte(value), nil
+	}
+	if filename == "" || filename == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(filename)
+}
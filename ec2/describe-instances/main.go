@@ -46,6 +46,7 @@ func main() {
 	kingpin.CommandLine.Name = "ec2-describe-instances"
 	kingpin.CommandLine.Help = "Returns metadata of one or more EC2 instances"
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
@@ -19,6 +19,7 @@ func main() {
 	kingpin.CommandLine.Name = "ec2-ip-from-name"
 	kingpin.CommandLine.Help = "Returns a list of instances IP with a given name."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
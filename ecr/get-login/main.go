@@ -18,6 +18,7 @@ func main() {
 	kingpin.CommandLine.Name = "ecr-get-login"
 	kingpin.CommandLine.Help = "Returns an authorization token from ECR."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
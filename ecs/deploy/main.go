@@ -27,6 +27,7 @@ func main() {
 	kingpin.CommandLine.Name = "ecs-deploy"
 	kingpin.CommandLine.Help = "Update a task definition on ECS."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
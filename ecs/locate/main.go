@@ -22,6 +22,7 @@ func main() {
 	kingpin.CommandLine.Name = "ecs-locate"
 	kingpin.CommandLine.Help = "Find an instance/port for a service"
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
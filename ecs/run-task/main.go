@@ -16,6 +16,7 @@ func main() {
 	kingpin.CommandLine.Name = "ecs-run-task"
 	kingpin.CommandLine.Help = "Run a task on ECS."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
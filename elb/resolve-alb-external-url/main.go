@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/service/elbv2"
@@ -20,6 +19,7 @@ func main() {
 	kingpin.CommandLine.Name = "elb-resolve-alb-external-url"
 	kingpin.CommandLine.Help = "Resolve the public URL of an ALB."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
@@ -65,7 +65,7 @@ func main() {
 		}
 	}
 	if !found {
-		os.Exit(1)
+		common.Exit(1)
 	}
 
 	fmt.Println(dnsName)
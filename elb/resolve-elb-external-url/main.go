@@ -19,6 +19,7 @@ func main() {
 	kingpin.CommandLine.Name = "elb-resolve-elb-external-url"
 	kingpin.CommandLine.Help = "Resolve the public URL of an ELB."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
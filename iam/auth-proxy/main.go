@@ -66,6 +66,7 @@ func main() {
 	kingpin.CommandLine.Name = "iam-auth-proxy"
 	kingpin.CommandLine.Help = "Proxy to generate IAM auth token"
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	proxy := goproxy.NewProxyHttpServer()
 	proxy.Verbose = false
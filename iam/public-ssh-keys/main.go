@@ -23,6 +23,7 @@ func main() {
 	kingpin.CommandLine.Name = "iam-public-ssh-keys"
 	kingpin.CommandLine.Help = "Return public SSH keys for an IAM user."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 
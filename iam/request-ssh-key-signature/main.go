@@ -99,6 +99,7 @@ func main() {
 	kingpin.CommandLine.Name = "iam-request-ssh-key-signature"
 	kingpin.CommandLine.Help = "Request a signature for a SSH key from lambda-sign-ssh-key."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 	HandleOptionalArgs()
 
 	sshPublicKeyBytes, err := ioutil.ReadFile(*sshPublicKeyFilename)
@@ -124,7 +125,7 @@ func main() {
 
 	if *dump {
 		fmt.Println(string(lambdaPayloadBytes))
-		os.Exit(0)
+		common.Exit(0)
 	}
 
 	lambdaClient := lambda.New(session, conf)
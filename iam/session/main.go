@@ -18,6 +18,7 @@ var (
 	quiet            = kingpin.Flag("quiet", "Do not output anything").Short('q').Default("false").Bool()
 	saveProfileName  = kingpin.Flag("save-profile", "Save the profile in the AWS credentials storage").Short('s').String()
 	overwriteProfile = kingpin.Flag("overwrite-profile", "Overwrite the profile if it already exists").Default("false").Bool()
+	output           = kingpin.Flag("output", "Print the credentials as bash, fish, powershell, docker or credential-process instead of running a command").Enum(OutputFormats...)
 	command          = kingpin.Arg("command", "Command to run, prefix with -- to pass args").Strings()
 )
 
@@ -25,13 +26,19 @@ func main() {
 	kingpin.CommandLine.Name = "iam-session"
 	kingpin.CommandLine.Help = "Start a new session under a different role."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	if len(*flags.RoleArn) == 0 && len(*saveProfileName) != 0 && len(*flags.MFASerialNumber) == 0 {
 		common.Fatalln("--save-profile can only be used with --assume-role-arn or --mfa-serial-number")
 	}
 
-	if len(*command) == 0 && len(*saveProfileName) == 0 {
-		common.Fatalln("Use at least one of command or --save-profile")
+	if len(*command) == 0 && len(*saveProfileName) == 0 && *output == "" {
+		common.Fatalln("Use at least one of command, --save-profile or --output")
+	}
+
+	// stdout only has the credentials with --output, --save-profile can print and prompt
+	if *output != "" && (len(*command) > 0 || len(*saveProfileName) > 0) {
+		common.Fatalln("--output cannot be used with a command or --save-profile")
 	}
 
 	session, conf := common.OpenSession(flags)
@@ -40,7 +47,8 @@ func main() {
 	res, err := stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
 	common.FatalOnError(err)
 
-	if !*quiet {
+	// stdout is reserved for the credentials with --output
+	if !*quiet && *output == "" {
 		fmt.Println(res)
 	}
 
@@ -50,15 +58,36 @@ func main() {
 		common.FatalOnError(err)
 	}
 
+	if *output != "" {
+		writeOutput(session.Config.Credentials, conf, &creds)
+	}
+
 	if len(*saveProfileName) != 0 {
 		saveProfile(conf, &creds)
 	}
 
 	if len(*command) > 0 {
-		os.Exit(executeCommand(command, conf, &creds))
+		common.Exit(executeCommand(command, conf, &creds))
 	}
 }
 
+// writeOutput uses the credentials of the session when no role is assumed.
+func writeOutput(sessionCredentials *credentials.Credentials, conf *aws.Config, creds *credentials.Value) {
+	provider := conf.Credentials
+	if provider == nil {
+		provider = sessionCredentials
+		value, err := provider.Get()
+		common.FatalOnError(err)
+		*creds = value
+	}
+
+	// zero when the provider does not expire
+	expiration, _ := provider.ExpiresAt()
+
+	err := writeCredentials(os.Stdout, *output, creds, aws.StringValue(conf.Region), expiration)
+	common.FatalOnErrorW(err, "Failed to write the credentials")
+}
+
 func saveProfile(conf *aws.Config, creds *credentials.Value) {
 	// update the credentials file
 	credsFilename := os.ExpandEnv("$HOME/.aws/credentials")
@@ -75,7 +104,7 @@ func saveProfile(conf *aws.Config, creds *credentials.Value) {
 			confirm := promptConfirm(fmt.Sprintf("The profile %s already exists, do you want to override it? (y/n) [n]: ", *saveProfileName))
 			if !confirm {
 				fmt.Println("Not overwriting profile")
-				os.Exit(0)
+				common.Exit(0)
 			}
 		}
 		if !*quiet {
@@ -139,7 +168,7 @@ func executeCommand(command *[]string, conf *aws.Config, creds *credentials.Valu
 		}
 		for _, v := range env {
 			s := strings.SplitN(v, "=", 2)
-			if strings.HasPrefix(s[0], "AWS") {
+			if strings.HasPrefix(s[0], "AWS") && !strings.HasPrefix(s[0], "AWS_ENDPOINT_URL") {
 				continue
 			}
 			pEnv = append(pEnv, v)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// OutputFormats are the values of --output.
+var OutputFormats = []string{"bash", "fish", "powershell", "docker", "credential-process"}
+
+// credentialProcess is the format expected from a credential_process in the
+// AWS config, credentials without Expiration never expire.
+type credentialProcess struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string `json:",omitempty"`
+	Expiration      string `json:",omitempty"`
+}
+
+// credentialVariables returns the environment variables of creds in a fixed order.
+func credentialVariables(creds *credentials.Value, region string) [][2]string {
+	variables := [][2]string{
+		{"AWS_ACCESS_KEY_ID", creds.AccessKeyID},
+		{"AWS_SECRET_ACCESS_KEY", creds.SecretAccessKey},
+	}
+	if creds.SessionToken != "" {
+		variables = append(variables, [2]string{"AWS_SESSION_TOKEN", creds.SessionToken})
+	}
+	if region != "" {
+		variables = append(variables, [2]string{"AWS_REGION", region})
+	}
+	return variables
+}
+
+func quoteSingle(value, quote, escapedQuote string) string {
+	return quote + strings.Replace(value, quote, escapedQuote, -1) + quote
+}
+
+// writeCredentials prints creds in format. expiration is only used by
+// credential-process and ignored if zero.
+func writeCredentials(w io.Writer, format string, creds *credentials.Value, region string, expiration time.Time) error {
+	if format == "credential-process" {
+		output := credentialProcess{
+			Version:         1,
+			AccessKeyId:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			SessionToken:    creds.SessionToken,
+		}
+		if !expiration.IsZero() {
+			output.Expiration = expiration.UTC().Format(time.RFC3339)
+		}
+		return json.NewEncoder(w).Encode(output)
+	}
+
+	for _, variable := range credentialVariables(creds, region) {
+		name, value := variable[0], variable[1]
+		var line string
+		switch format {
+		case "bash":
+			line = fmt.Sprintf("export %s=%s", name, quoteSingle(value, "'", `'\''`))
+		case "fish":
+			value = strings.Replace(value, `\`, `\\`, -1)
+			line = fmt.Sprintf("set -gx %s %s", name, quoteSingle(value, "'", `\'`))
+		case "powershell":
+			line = fmt.Sprintf("$Env:%s = %s", name, quoteSingle(value, "'", "''"))
+		case "docker":
+			line = fmt.Sprintf("%s=%s", name, value)
+		default:
+			return fmt.Errorf("Unknown output format %s", format)
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCredentials(t *testing.T) {
+	creds := &credentials.Value{AccessKeyID: "AKID", SecretAccessKey: `se'cr\et`, SessionToken: "token"}
+	expiration := time.Date(2020, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	tests := map[string]string{
+		"bash": "export AWS_ACCESS_KEY_ID='AKID'\nexport AWS_SECRET_ACCESS_KEY='se'\\''cr\\et'\n" +
+			"export AWS_SESSION_TOKEN='token'\nexport AWS_REGION='eu-west-1'\n",
+		"fish": "set -gx AWS_ACCESS_KEY_ID 'AKID'\nset -gx AWS_SECRET_ACCESS_KEY 'se\\'cr\\\\et'\n" +
+			"set -gx AWS_SESSION_TOKEN 'token'\nset -gx AWS_REGION 'eu-west-1'\n",
+		"powershell": "$Env:AWS_ACCESS_KEY_ID = 'AKID'\n$Env:AWS_SECRET_ACCESS_KEY = 'se''cr\\et'\n" +
+			"$Env:AWS_SESSION_TOKEN = 'token'\n$Env:AWS_REGION = 'eu-west-1'\n",
+		"docker": "AWS_ACCESS_KEY_ID=AKID\nAWS_SECRET_ACCESS_KEY=se'cr\\et\nAWS_SESSION_TOKEN=token\nAWS_REGION=eu-west-1\n",
+		"credential-process": `{"Version":1,"AccessKeyId":"AKID","SecretAccessKey":"se'cr\\et",` +
+			`"SessionToken":"token","Expiration":"2020-01-02T02:04:05Z"}` + "\n",
+	}
+	for format, expected := range tests {
+		var buf bytes.Buffer
+		require.NoError(t, writeCredentials(&buf, format, creds, "eu-west-1", expiration), format)
+		assert.Equal(t, expected, buf.String(), format)
+	}
+
+	var buf bytes.Buffer
+	static := &credentials.Value{AccessKeyID: "AKID", SecretAccessKey: "secret"}
+	require.NoError(t, writeCredentials(&buf, "credential-process", static, "", time.Time{}))
+	assert.Equal(t, `{"Version":1,"AccessKeyId":"AKID","SecretAccessKey":"secret"}`+"\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeCredentials(&buf, "bash", static, "", time.Time{}))
+	assert.Equal(t, "export AWS_ACCESS_KEY_ID='AKID'\nexport AWS_SECRET_ACCESS_KEY='secret'\n", buf.String())
+
+	assert.Error(t, writeCredentials(&buf, "cmd", static, "", time.Time{}))
+}
@@ -37,6 +37,7 @@ func main() {
 	kingpin.CommandLine.Name = "iam-sync-users"
 	kingpin.CommandLine.Help = "Sync local users with IAM"
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 	common.FatalOnError(ensureCanCreateUser())
 
 	session, conf := common.OpenSession(flags)
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/hamstah/awstools/common"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	ciphertext        = kingpin.Arg("ciphertext", "Value to decrypt, read from --input-file or stdin if not set").String()
+	inputFile         = kingpin.Flag("input-file", "File to decrypt, - for stdin").String()
+	inputFormat       = kingpin.Flag("input-format", "Input format").Default("base64").Enum("base64", "raw")
+	encryptionContext = kingpin.Flag("encryption-context", "Expected encryption context key=value, can be repeated").StringMap()
+	outputFile        = kingpin.Flag("output-file", "Write the plaintext to this file with mode 0600 instead of stdout").String()
+)
+
+func main() {
+	kingpin.CommandLine.Name = "kms-decrypt"
+	kingpin.CommandLine.Help = "Decrypt a value encrypted with kms-encrypt, a legacy secretbox envelope or KMS."
+	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
+
+	input, err := common.ReadInput(*ciphertext, *inputFile)
+	common.FatalOnErrorW(err, "Failed to read the input")
+
+	value := strings.TrimSpace(string(input))
+	if *inputFormat == "raw" {
+		value = base64.StdEncoding.EncodeToString(input)
+	}
+
+	session, conf := common.OpenSession(flags)
+	kmsClient := kms.New(session, conf)
+
+	var plaintext []byte
+	if len(*encryptionContext) > 0 {
+		plaintext, err = common.DecryptWithKMSContext(kmsClient, value, *encryptionContext)
+	} else {
+		plaintext, err = common.DecryptWithKMS(kmsClient, value)
+	}
+	common.FatalOnErrorW(err, "Failed to decrypt")
+
+	if *outputFile != "" {
+		err = ioutil.WriteFile(*outputFile, plaintext, 0600)
+		common.FatalOnErrorW(err, "Failed to write the output file")
+		return
+	}
+	os.Stdout.Write(plaintext)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/hamstah/awstools/common"
+	log "github.com/sirupsen/logrus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	plaintext         = kingpin.Arg("plaintext", "Value to encrypt, read from --input-file or stdin if not set").String()
+	inputFile         = kingpin.Flag("input-file", "File to encrypt, - for stdin").String()
+	trimNewline       = kingpin.Flag("trim-newline", "Remove the trailing newline of the input").Default("true").Bool()
+	keyID             = kingpin.Flag("key-id", "KMS key id, ARN or alias").Required().String()
+	encryptionContext = kingpin.Flag("encryption-context", "Encryption context key=value, can be repeated").StringMap()
+	algorithm         = kingpin.Flag("algorithm", "Algorithm used to encrypt the value with the data key").Default(common.AlgorithmSecretBox).Enum(common.EnvelopeAlgorithms...)
+	output            = kingpin.Flag("output", "Output format").Default("base64").Enum("base64", "raw")
+	ssmParameter      = kingpin.Flag("ssm-parameter", "Write the base64 ciphertext to this SSM parameter instead of stdout").String()
+	ssmOverwrite      = kingpin.Flag("ssm-overwrite", "Overwrite the SSM parameter if it exists").Bool()
+)
+
+func main() {
+	kingpin.CommandLine.Name = "kms-encrypt"
+	kingpin.CommandLine.Help = "Encrypt a value in a KMS envelope for kms-env and kms-decrypt."
+	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
+
+	input, err := common.ReadInput(*plaintext, *inputFile)
+	common.FatalOnErrorW(err, "Failed to read the input")
+	if *plaintext == "" && *trimNewline {
+		input = []byte(strings.TrimSuffix(strings.TrimSuffix(string(input), "\n"), "\r"))
+	}
+
+	session, conf := common.OpenSession(flags)
+
+	ciphertext, err := common.EncryptEnvelope(kms.New(session, conf), input, *keyID, &common.EnvelopeOptions{
+		Algorithm:         *algorithm,
+		EncryptionContext: *encryptionContext,
+	})
+	common.FatalOnErrorW(err, "Failed to encrypt")
+
+	if *ssmParameter != "" {
+		_, err = ssm.New(session, conf).PutParameter(&ssm.PutParameterInput{
+			Name:      ssmParameter,
+			Type:      aws.String("String"),
+			Value:     aws.String(ciphertext),
+			Overwrite: ssmOverwrite,
+		})
+		common.FatalOnErrorW(err, "Failed to write the SSM parameter")
+		log.WithField("name", *ssmParameter).Info("Wrote SSM parameter")
+		return
+	}
+
+	if *output == "raw" {
+		data, err := base64.StdEncoding.DecodeString(ciphertext)
+		common.FatalOnError(err)
+		os.Stdout.Write(data)
+		return
+	}
+	fmt.Println(ciphertext)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hamstah/awstools/common"
+)
+
+var (
+	envKeyRegexp       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+	envBareValueRegexp = regexp.MustCompile(`^[A-Za-z0-9_./:@+=,-]*$`)
+)
+
+// ExportFormats are the values of --export.
+var ExportFormats = []string{"dotenv", "shell", "json", "docker"}
+
+// parseDotenv reads KEY=VALUE lines with optional export, comments and
+// quotes. Single quoted values are literal, double quoted values support
+// \n \r \t \" \\ and \$ escapes and both can span multiple lines.
+func parseDotenv(content string) ([]string, error) {
+	res := []string{}
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		number := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "export ") {
+			line = strings.TrimSpace(line[len("export "):])
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || !envKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("Invalid line %d, should be KEY=VALUE", number)
+		}
+		value := strings.TrimSpace(parts[1])
+
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+			res = append(res, key+"="+value)
+			continue
+		}
+
+		quote := value[0]
+		raw := value[1:]
+		end := closingQuote(raw, quote)
+		for end < 0 {
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("Unterminated quoted value on line %d", number)
+			}
+			raw += "\n" + lines[i]
+			end = closingQuote(raw, quote)
+		}
+
+		rest := strings.TrimSpace(raw[end+1:])
+		if rest != "" && !strings.HasPrefix(rest, "#") {
+			return nil, fmt.Errorf("Unexpected characters after the quoted value on line %d", number)
+		}
+
+		value = raw[:end]
+		if quote == '"' {
+			value = unescapeDotenv(value)
+		}
+		res = append(res, key+"="+value)
+	}
+	return res, nil
+}
+
+// closingQuote returns the index of the quote ending value, double quotes
+// can be escaped.
+func closingQuote(value string, quote byte) int {
+	for i := 0; i < len(value); i++ {
+		if quote == '"' && value[i] == '\\' {
+			i++
+			continue
+		}
+		if value[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+var dotenvUnescaper = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, `$`)
+
+func unescapeDotenv(value string) string {
+	return dotenvUnescaper.Replace(value)
+}
+
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`, `$`, `\$`)
+
+// quoteDotenv quotes values so parseDotenv reads them back unchanged.
+func quoteDotenv(value string) string {
+	if envBareValueRegexp.MatchString(value) {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\r") {
+		return "'" + value + "'"
+	}
+	return `"` + dotenvEscaper.Replace(value) + `"`
+}
+
+func quoteShell(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// parseJSONEnv reads a JSON object, values which are not strings are
+// converted with EnvironmentValue. Numbers keep their precision.
+func parseJSONEnv(content []byte) ([]string, error) {
+	object := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err := decoder.Decode(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	res := []string{}
+	for _, key := range keys {
+		if !envKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("Invalid variable name %s", key)
+		}
+		res = append(res, key+"="+common.EnvironmentValue(object[key]))
+	}
+	return res, nil
+}
+
+// readEnvFiles returns the variables of dotenv files or JSON objects, later
+// files override earlier ones.
+func readEnvFiles(filenames []string) ([]string, error) {
+	res := []string{}
+	for _, filename := range filenames {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var variables []string
+		if strings.HasPrefix(strings.TrimSpace(string(content)), "{") {
+			variables, err = parseJSONEnv(content)
+		} else {
+			variables, err = parseDotenv(string(content))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		res = append(res, variables...)
+	}
+	return res, nil
+}
+
+// writeEnvironment prints env in format with sorted keys.
+func writeEnvironment(w io.Writer, env map[string]string, format string) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := env[key]
+		var line string
+		switch format {
+		case "dotenv":
+			line = fmt.Sprintf("%s=%s", key, quoteDotenv(value))
+		case "shell":
+			line = fmt.Sprintf("export %s=%s", key, quoteShell(value))
+		case "docker":
+			// docker env files have no quoting, values are used as is
+			if strings.ContainsAny(value, "\n\r") {
+				return fmt.Errorf("%s contains a newline which is not supported in docker env files", key)
+			}
+			line = fmt.Sprintf("%s=%s", key, value)
+		default:
+			return fmt.Errorf("Unknown format %s", format)
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDotenv(t *testing.T) {
+	variables, err := parseDotenv(`
+# comment
+A=1
+export B = two words # comment
+SSM_C='/app/*'
+D="line\nnext \"quoted\" \$HOME"
+E="-----BEGIN KEY-----
+abc
+-----END KEY-----"
+F=
+G='a # b' # comment
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"A=1",
+		"B=two words",
+		"SSM_C=/app/*",
+		"D=line\nnext \"quoted\" $HOME",
+		"E=-----BEGIN KEY-----\nabc\n-----END KEY-----",
+		"F=",
+		"G=a # b",
+	}, variables)
+
+	for _, content := range []string{"A", "1A=b", `A="unterminated`, `A="a" b`} {
+		_, err = parseDotenv(content)
+		assert.Error(t, err, content)
+	}
+}
+
+func TestWriteEnvironment(t *testing.T) {
+	env := map[string]string{
+		"A": "plain",
+		"B": "it's $HOME",
+		"C": "two words",
+		"D": "a\nb",
+	}
+
+	buffer := &bytes.Buffer{}
+	require.NoError(t, writeEnvironment(buffer, env, "dotenv"))
+	assert.Equal(t, "A=plain\nB=\"it's \\$HOME\"\nC='two words'\nD=\"a\\nb\"\n", buffer.String())
+
+	variables, err := parseDotenv(buffer.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A=plain", "B=it's $HOME", "C=two words", "D=a\nb"}, variables)
+
+	buffer.Reset()
+	require.NoError(t, writeEnvironment(buffer, env, "shell"))
+	assert.Equal(t, "export A='plain'\nexport B='it'\\''s $HOME'\nexport C='two words'\nexport D='a\nb'\n", buffer.String())
+
+	buffer.Reset()
+	require.NoError(t, writeEnvironment(buffer, env, "json"))
+	assert.JSONEq(t, `{"A": "plain", "B": "it's $HOME", "C": "two words", "D": "a\nb"}`, buffer.String())
+
+	buffer.Reset()
+	assert.Error(t, writeEnvironment(buffer, env, "docker"))
+
+	buffer.Reset()
+	require.NoError(t, writeEnvironment(buffer, map[string]string{"A": "a b", "B": "'c'"}, "docker"))
+	assert.Equal(t, "A=a b\nB='c'\n", buffer.String())
+}
+
+func TestParseJSONEnv(t *testing.T) {
+	variables, err := parseJSONEnv([]byte(`{"B": 2, "A": "one", "SSM_C": "/app/*", "D": {"e": true}, "ACCOUNT_ID": 123456789012}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"A=one", "ACCOUNT_ID=123456789012", "B=2", `D={"e":true}`, "SSM_C=/app/*"}, variables)
+
+	_, err = parseJSONEnv([]byte(`{"A B": "c"}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// SecretFiles writes variables to files in a private directory so they are
+// not visible in the environment of the command.
+type SecretFiles struct {
+	Dir      string
+	Patterns []string
+}
+
+// defaultSecretsParent returns /dev/shm when it exists as it is a tmpfs on
+// most Linux systems and the files are never written to disk.
+func defaultSecretsParent() string {
+	info, err := os.Stat("/dev/shm")
+	if err == nil && info.IsDir() {
+		return "/dev/shm"
+	}
+	return os.TempDir()
+}
+
+// NewSecretFiles creates a directory only readable by the current user in
+// parent, or in the default location if parent is empty.
+func NewSecretFiles(parent string, patterns []string) (*SecretFiles, error) {
+	if parent == "" {
+		parent = defaultSecretsParent()
+	}
+	dir, err := ioutil.TempDir(parent, "kms-env-")
+	if err != nil {
+		return nil, err
+	}
+	return &SecretFiles{Dir: dir, Patterns: patterns}, nil
+}
+
+func (f *SecretFiles) match(name string) bool {
+	for _, pattern := range f.Patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply writes the variables matching the patterns which are not in static
+// to files and replaces them with NAME_FILE variables set to the path of the
+// files. Files of variables which are no longer set are removed.
+func (f *SecretFiles) Apply(env map[string]string, static map[string]interface{}) (map[string]string, error) {
+	res := map[string]string{}
+	written := map[string]bool{}
+
+	for name, value := range env {
+		if _, ok := static[name].(string); ok || !f.match(name) {
+			res[name] = value
+			continue
+		}
+
+		filename := filepath.Join(f.Dir, name)
+		err := writeSecretFile(filename, value)
+		if err != nil {
+			return nil, err
+		}
+		res[name+"_FILE"] = filename
+		written[name] = true
+	}
+
+	existing, err := ioutil.ReadDir(f.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range existing {
+		if !written[info.Name()] {
+			os.Remove(filepath.Join(f.Dir, info.Name()))
+		}
+	}
+	return res, nil
+}
+
+// writeSecretFile replaces the file atomically so readers never see a
+// partial value.
+func writeSecretFile(filename, value string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(value)
+	if err == nil {
+		err = tmp.Chmod(0400)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (f *SecretFiles) Remove() {
+	os.RemoveAll(f.Dir)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretFiles(t *testing.T) {
+	parent, err := ioutil.TempDir("", "test")
+	require.NoError(t, err)
+	defer os.RemoveAll(parent)
+
+	files, err := NewSecretFiles(parent, []string{"DB_*", "TOKEN"})
+	require.NoError(t, err)
+
+	info, err := os.Stat(files.Dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	static := map[string]interface{}{"DB_HOST": "localhost"}
+	env, err := files.Apply(map[string]string{
+		"DB_HOST":     "localhost",
+		"DB_PASSWORD": "secret",
+		"TOKEN":       "token",
+		"REGION":      "eu-west-1",
+	}, static)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":          "localhost",
+		"DB_PASSWORD_FILE": filepath.Join(files.Dir, "DB_PASSWORD"),
+		"TOKEN_FILE":       filepath.Join(files.Dir, "TOKEN"),
+		"REGION":           "eu-west-1",
+	}, env)
+
+	content, err := ioutil.ReadFile(env["DB_PASSWORD_FILE"])
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(content))
+	info, err = os.Stat(env["DB_PASSWORD_FILE"])
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0400), info.Mode().Perm())
+
+	_, err = files.Apply(map[string]string{"DB_PASSWORD": "rotated"}, static)
+	require.NoError(t, err)
+	content, err = ioutil.ReadFile(env["DB_PASSWORD_FILE"])
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", string(content))
+	_, err = os.Stat(env["TOKEN_FILE"])
+	assert.True(t, os.IsNotExist(err))
+
+	entries, err := ioutil.ReadDir(files.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	files.Remove()
+	_, err = os.Stat(files.Dir)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path"
+)
+
+// awsCredentialVariables are removed by --scrub-aws-credentials. The
+// container credentials variables are kept as they are the role of the task.
+var awsCredentialVariables = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_SECURITY_TOKEN",
+	"AWS_CREDENTIAL_EXPIRATION",
+	"AWS_PROFILE",
+	"AWS_DEFAULT_PROFILE",
+	"AWS_SHARED_CREDENTIALS_FILE",
+	"AWS_WEB_IDENTITY_TOKEN_FILE",
+	"AWS_ROLE_ARN",
+	"AWS_ROLE_SESSION_NAME",
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEnvironment applies --allow, --deny and --scrub-aws-credentials.
+func filterEnvironment(env map[string]string) map[string]string {
+	res := map[string]string{}
+	for name, value := range env {
+		if len(*allowVariables) > 0 && !matchAny(*allowVariables, name) {
+			continue
+		}
+		if matchAny(*denyVariables, name) {
+			continue
+		}
+		if *scrubAWSCredentials && matchAny(awsCredentialVariables, name) {
+			continue
+		}
+		res[name] = value
+	}
+	return res
+}
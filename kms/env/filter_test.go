@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterEnvironment(t *testing.T) {
+	env := map[string]string{
+		"AWS_ACCESS_KEY_ID":     "id",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_REGION":            "eu-west-1",
+		"DB_HOST":               "localhost",
+		"DB_PASSWORD":           "password",
+		"HOME":                  "/root",
+	}
+	defer func() {
+		*allowVariables = nil
+		*denyVariables = nil
+		*scrubAWSCredentials = false
+	}()
+
+	assert.Equal(t, env, filterEnvironment(env))
+
+	*scrubAWSCredentials = true
+	assert.Equal(t, map[string]string{
+		"AWS_REGION":  "eu-west-1",
+		"DB_HOST":     "localhost",
+		"DB_PASSWORD": "password",
+		"HOME":        "/root",
+	}, filterEnvironment(env))
+
+	*allowVariables = []string{"DB_*", "AWS_*"}
+	*denyVariables = []string{"DB_PASSWORD"}
+	assert.Equal(t, map[string]string{
+		"AWS_REGION": "eu-west-1",
+		"DB_HOST":    "localhost",
+	}, filterEnvironment(env))
+}
@@ -2,142 +2,183 @@ package main
 
 import (
 	"bytes"
-	"encoding/base64"
-	"encoding/gob"
-	"encoding/json"
-	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"reflect"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
-	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/hamstah/awstools/common"
-	"golang.org/x/crypto/nacl/secretbox"
+	log "github.com/sirupsen/logrus"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	command                    = kingpin.Arg("command", "Command to run, prefix with -- to pass args").Required().Strings()
-	kmsPrefix                  = kingpin.Flag("kms-prefix", "Prefix for the KMS environment variables").Default("KMS_").String()
-	ssmPrefix                  = kingpin.Flag("ssm-prefix", "Prefix for the SSM environment variables").Default("SSM_").String()
-	secretsManagerPrefix       = kingpin.Flag("secrets-manager-prefix", "Prefix for the secrets manager environment variables").Default("SECRETS_MANAGER_").String()
-	secretsManagerVersionStage = kingpin.Flag("secrets-manager-version-stage", "The version stage of secrets from secrets manager").Default("AWSCURRENT").String()
-	refreshInterval            = kingpin.Flag("refresh-interval", "Refresh interval").Default("0").Duration()
-	refreshAction              = kingpin.Flag("refresh-action", "Action to take when values have changed").Default("RESTART").Enum("RESTART", "EXIT")
-	refreshMaxRetries          = kingpin.Flag("refresh-max-retries", "Number of retries when failing to refresh the config").Default("5").Int()
+	command                     = kingpin.Arg("command", "Command to run, prefix with -- to pass args").Strings()
+	kmsPrefix                   = kingpin.Flag("kms-prefix", "Prefix for the KMS environment variables").Default("KMS_").String()
+	ssmPrefix                   = kingpin.Flag("ssm-prefix", "Prefix for the SSM environment variables").Default("SSM_").String()
+	secretsManagerPrefix        = kingpin.Flag("secrets-manager-prefix", "Prefix for the secrets manager environment variables").Default("SECRETS_MANAGER_").String()
+	filePrefix                  = kingpin.Flag("file-prefix", "Prefix for the file environment variables, disabled if empty").Default("").String()
+	valuePrefixes               = kingpin.Flag("value-prefixes", "Load values starting with kms://, ssm:// or secrets-manager://").Default("true").Bool()
+	fileValues                  = kingpin.Flag("file-values", "Also load values starting with file://, existing variables like DATABASE_URL=file:///var/db.sqlite would be replaced").Bool()
+	secretsManagerVersionStage  = kingpin.Flag("secrets-manager-version-stage", "The version stage of secrets from secrets manager").Default("AWSCURRENT").String()
+	secretsManagerPending       = kingpin.Flag("secrets-manager-pending", "Also load the AWSPENDING version of the secrets from secrets manager, with --secrets-manager-pending-suffix added to the names").Bool()
+	secretsManagerPendingSuffix = kingpin.Flag("secrets-manager-pending-suffix", "Suffix of the names of the variables from the AWSPENDING version of secrets").Default("_PENDING").String()
+	refreshInterval             = kingpin.Flag("refresh-interval", "Refresh interval").Default("0").Duration()
+	refreshAction               = kingpin.Flag("refresh-action", "Action to take when values have changed, RELOAD_FILES and SIGNAL only rewrite the secret files if the other variables did not change").Default("RESTART").Enum("RESTART", "EXIT", "RELOAD_FILES", "SIGNAL")
+	refreshSignal               = kingpin.Flag("refresh-signal", "Signal sent to the command with --refresh-action SIGNAL").Default("SIGHUP").String()
+	stopTimeout                 = kingpin.Flag("stop-timeout", "Time to wait for the command to stop after SIGTERM before sending SIGKILL, 0 to wait forever").Default("10s").Duration()
+	reapZombies                 = kingpin.Flag("reap-zombies", "Reap orphaned processes, enabled when running as PID 1").Bool()
+	refreshMaxRetries           = kingpin.Flag("refresh-max-retries", "Number of retries when failing to refresh the config").Default("5").Int()
+	secretFiles                 = kingpin.Flag("secret-file", "Write the variables matching this pattern to a file and set NAME_FILE to its path instead, can be repeated").Strings()
+	secretsDir                  = kingpin.Flag("secrets-dir", "Directory in which to create the private directory of the secret files, defaults to /dev/shm if available").String()
+	envFiles                    = kingpin.Flag("env-file", "Dotenv file with variables to load in addition to the environment, can be repeated").Strings()
+	export                      = kingpin.Flag("export", "Print the variables loaded from sources and env files in this format instead of running a command").Enum(ExportFormats...)
+	allowOverride               = kingpin.Flag("allow-override", "Allow sources to override variables already set, otherwise fail").Bool()
+	allowVariables              = kingpin.Flag("allow", "Only pass the variables matching this pattern to the command, can be repeated").Strings()
+	denyVariables               = kingpin.Flag("deny", "Do not pass the variables matching this pattern to the command, can be repeated").Strings()
+	scrubAWSCredentials         = kingpin.Flag("scrub-aws-credentials", "Do not pass the AWS credentials used to load the values to the command").Bool()
+	statusAddr                  = kingpin.Flag("status-addr", "Address of the unauthenticated HTTP status endpoint, like :8080 for 127.0.0.1:8080, disabled if empty").String()
+	statusHashKeyFile           = kingpin.Flag("status-hash-key-file", "File with the key of the environment hash of the status endpoint, random by default so the hash changes between restarts and replicas").String()
+	healthMaxFailures           = kingpin.Flag("health-max-failures", "Number of consecutive refresh failures after which /health reports unhealthy").Default("3").Int()
+	validate                    = kingpin.Flag("validate", "Check the values can be accessed without fetching them and exit").Bool()
+	explain                     = kingpin.Flag("explain", "List the values, their source and whether they resolve and exit").Bool()
 )
 
-type Source struct {
-	Name       string
-	Identifier string
-}
+// configValues loads the sources from env. S3 values are not loaded as s3://
+// URLs are common in environments.
+func configValues(env []string) *common.ConfigValues {
+	c := common.NewConfigValues()
+	c.MaxRetries = *refreshMaxRetries
+	c.KeyPrefixes = map[string]string{
+		"KMS":             *kmsPrefix,
+		"SSM":             *ssmPrefix,
+		"SECRETS_MANAGER": *secretsManagerPrefix,
+		"FILE":            *filePrefix,
+	}
+	// s3:// and file:// are common in existing variables
+	delete(c.ValuePrefixes, "S3")
+	if !*fileValues {
+		delete(c.ValuePrefixes, "FILE")
+	}
+	if !*valuePrefixes {
+		c.ValuePrefixes = map[string]string{}
+	}
+	c.Resolvers["SECRETS_MANAGER"] = common.NewSecretsManagerResolver(*secretsManagerVersionStage)
 
-type Config struct {
-	Sources           map[string][]Source
-	StaticEnvironment map[string]string
+	c.SetFromEnvironment(env)
+	return c
 }
 
-func (c *Config) IsRefreshable() bool {
-	return len(c.Sources) > 0
+// pendingConfigValues only keeps the Secrets Manager sources of c, they are
+// resolved with pendingResolver.
+func pendingConfigValues(c *common.ConfigValues) *common.ConfigValues {
+	pending := common.NewConfigValues()
+	pending.MaxRetries = c.MaxRetries
+	pending.Resolvers["SECRETS_MANAGER"] = pendingResolver{}
+	for key, value := range c.Static {
+		if source, ok := value.(common.Source); ok && source.Type == "SECRETS_MANAGER" {
+			pending.Static[key] = source
+		}
+	}
+	return pending
 }
 
-func (c *Config) RefreshWithRetries(flags *common.SessionFlags) (map[string]string, error) {
+// pendingResolver resolves the AWSPENDING version of secrets, or the version
+// of --secrets-manager-version-stage outside of rotations. Secrets with a
+// pinned version resolve to that version.
+type pendingResolver struct{}
 
-	wait := 2
+func (r pendingResolver) Resolve(source common.Source, state *common.RefreshState) (interface{}, error) {
+	value, err := common.NewSecretsManagerResolver("AWSPENDING").Resolve(source, state)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return common.NewSecretsManagerResolver(*secretsManagerVersionStage).Resolve(source, state)
+	}
+	return value, err
+}
 
-	for i := 0; i < *refreshMaxRetries; i++ {
-		result, err := c.Refresh(flags)
-		if err == nil {
-			return result, nil
-		}
+// Loader loads the variables of the command.
+type Loader struct {
+	Config *common.ConfigValues
+	// Pending is set with --secrets-manager-pending.
+	Pending *common.ConfigValues
+}
 
-		wait = wait * 2
-		time.Sleep(time.Duration(wait) * time.Second)
+func newLoader(env []string) *Loader {
+	loader := &Loader{Config: configValues(env)}
+	if *secretsManagerPending {
+		loader.Pending = pendingConfigValues(loader.Config)
 	}
-	return nil, errors.New("Failed to refresh config")
+	return loader
 }
 
-func (c *Config) Refresh(flags *common.SessionFlags) (map[string]string, error) {
-	env := map[string]string{}
+func (l *Loader) IsRefreshable() bool {
+	return l.Config.IsRefreshable()
+}
 
-	session, conf := common.OpenSession(flags)
+func (l *Loader) Refresh(session *session.Session, conf *aws.Config) (map[string]string, error) {
+	values := map[string]interface{}{}
+	err := l.Config.RefreshWithRetries(session, conf, &values)
+	if err != nil {
+		return nil, err
+	}
+	env, err := l.Config.Environment(values, *allowOverride)
+	if err != nil {
+		return nil, err
+	}
 
-	for secretType, sources := range c.Sources {
-		switch secretType {
-		case "SSM":
-			ssmClient := ssm.New(session, conf)
-			for _, source := range sources {
-				if strings.HasSuffix(source.Identifier, "/*") {
-					values, err := getParametersByPath(ssmClient, source.Identifier[:len(source.Identifier)-2], source.Name)
-					if err != nil {
-						return nil, err
-					}
-					for key, value := range values {
-						env[key] = value
-					}
-				} else {
-					value, err := ssmGetParameter(ssmClient, source.Identifier)
-					if err != nil {
-						return nil, err
-					}
-					env[source.Name] = value
-				}
-			}
-		case "SECRETS_MANAGER":
-			secretsManagerClient := secretsmanager.New(session, conf)
-			for _, source := range sources {
-				values, err := secretsManagerGetSecretValue(secretsManagerClient, source.Identifier, source.Name)
-				if err != nil {
-					return nil, err
-				}
-				for key, value := range values {
-					env[key] = value
-				}
-			}
-		case "KMS":
-			kmsClient := kms.New(session, conf)
-			for _, source := range sources {
-				value, err := kmsDecrypt(kmsClient, source.Identifier)
-				if err != nil {
-					return nil, err
-				}
-				env[source.Name] = value
+	if l.Pending != nil {
+		values := map[string]interface{}{}
+		err := l.Pending.RefreshWithRetries(session, conf, &values)
+		if err != nil {
+			return nil, err
+		}
+		pendingEnv, err := l.Pending.Environment(values, true)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range pendingEnv {
+			name += *secretsManagerPendingSuffix
+			if _, ok := env[name]; ok && !*allowOverride {
+				return nil, fmt.Errorf("%s is set by both the environment and the pending version of a secret", name)
 			}
+			env[name] = value
 		}
 	}
-
-	for key, value := range c.StaticEnvironment {
-		env[key] = value
-	}
-
-	return env, nil
+	return filterEnvironment(env), nil
 }
 
-func Monitor(flags *common.SessionFlags, config *Config, comm chan<- map[string]string) {
-	previous, err := config.RefreshWithRetries(flags)
+// Monitor sends the environment to comm when it changes, or nil if it cannot
+// be loaded the first time. Refresh failures after that are only recorded,
+// the command keeps running with the previous values.
+func Monitor(session *session.Session, conf *aws.Config, loader *Loader, status *Status, comm chan<- map[string]string) {
+	previous, err := loader.Refresh(session, conf)
 	if err != nil {
+		log.WithError(err).Error("Failed to refresh the environment")
+		status.Failed(err)
 		comm <- nil
 		return
 	}
+	status.Refreshed(previous)
 	comm <- previous
 
-	if !config.IsRefreshable() || *refreshInterval == time.Duration(0) {
+	if !loader.IsRefreshable() || *refreshInterval == time.Duration(0) {
 		return
 	}
 
 	for _ = range time.Tick(*refreshInterval) {
-		new, err := config.RefreshWithRetries(flags)
+		new, err := loader.Refresh(session, conf)
 		if err != nil {
-			comm <- nil
-			return
+			log.WithError(err).Error("Failed to refresh the environment")
+			status.Failed(err)
+			continue
 		}
+		status.Refreshed(new)
 
 		if !reflect.DeepEqual(new, previous) {
 			comm <- new
@@ -146,219 +187,165 @@ func Monitor(flags *common.SessionFlags, config *Config, comm chan<- map[string]
 	}
 }
 
-func ParseConfig(env []string) (*Config, error) {
-	config := &Config{
-		Sources:           map[string][]Source{},
-		StaticEnvironment: map[string]string{},
+// checkConfig only lists the variables loaded from a source.
+func checkConfig(flags *common.SessionFlags, env []string) {
+	c := configValues(env)
+	for key, value := range c.Static {
+		if _, ok := value.(common.Source); !ok {
+			delete(c.Static, key)
+		}
 	}
 
-	prefixes := map[string]string{
-		"KMS":             *kmsPrefix,
-		"SSM":             *ssmPrefix,
-		"SECRETS_MANAGER": *secretsManagerPrefix,
+	session, conf := common.OpenSession(flags)
+
+	if *validate {
+		common.FatalOnError(c.Validate(session, conf))
 	}
-	for _, value := range env {
-		parts := strings.SplitN(value, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
 
-		key := parts[0]
-		value := parts[1]
+	if *explain {
+		failed := common.WriteExplain(os.Stdout, c.Explain(session, conf))
+		if failed > 0 {
+			common.Fatalln(fmt.Sprintf("%d values failed to resolve", failed))
+		}
+	}
+}
 
-		found := false
-		for secretType, prefix := range prefixes {
-			if strings.HasPrefix(key, prefix) {
-				key := key[len(prefix):]
+// exportEnvironment prints the variables except the ones inherited from the
+// environment of kms-env without a source.
+func exportEnvironment(flags *common.SessionFlags, env []string, fileEnv []string) {
+	if len(*secretFiles) > 0 {
+		common.Fatalln("--secret-file cannot be used with --export")
+	}
 
-				name := key
-				if strings.HasPrefix(key, "_") {
-					name = ""
-				}
+	fromFiles := map[string]bool{}
+	for _, variable := range fileEnv {
+		fromFiles[strings.SplitN(variable, "=", 2)[0]] = true
+	}
 
-				config.Sources[secretType] = append(config.Sources[secretType], Source{
-					Name:       name,
-					Identifier: value,
-				})
-				found = false
-				break
-			}
-		}
+	session, conf := common.OpenSession(flags)
+	loader := newLoader(env)
+	resolved, err := loader.Refresh(session, conf)
+	common.FatalOnErrorW(err, "Failed to load the environment")
 
-		if !found {
-			config.StaticEnvironment[key] = value
+	for key := range resolved {
+		if _, ok := loader.Config.Static[key].(string); ok && !fromFiles[key] {
+			delete(resolved, key)
 		}
-
 	}
-
-	return config, nil
+	common.FatalOnError(writeEnvironment(os.Stdout, resolved, *export))
 }
 
 func main() {
 	kingpin.CommandLine.Name = "kms-env"
 	kingpin.CommandLine.Help = "Decrypt environment variables encrypted with KMS, SSM or Secret Manager."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	env := os.Environ()
+	fileEnv, err := readEnvFiles(*envFiles)
+	common.FatalOnErrorW(err, "Failed to read the env files")
+	env = append(env, fileEnv...)
 
-	config, err := ParseConfig(env)
-	common.FatalOnError(err)
-
-	comm := make(chan map[string]string, 1)
-	go Monitor(flags, config, comm)
-
-	var p *exec.Cmd
-
-	waitingPid := -1
-
-	for envMap := range comm {
-		if envMap == nil {
-			// failed to refresh config, don't kill the process
-			// stay alive even if config potentially out of date is better than a crash
-			continue
-		}
-
-		if p != nil {
-			waitingPid = p.Process.Pid
-			p.Process.Signal(syscall.SIGTERM)
-			p.Wait()
-
-			if *refreshAction == "EXIT" {
-				os.Exit(0)
-			}
-		}
-
-		var pEnv []string
-		for key, value := range envMap {
-			pEnv = append(pEnv, fmt.Sprintf("%s=%s", key, value))
-		}
+	if *validate || *explain {
+		checkConfig(flags, env)
+		return
+	}
 
-		p = exec.Command((*command)[0], (*command)[1:]...)
-		p.Env = pEnv
-		p.Stdin = os.Stdin
-		p.Stderr = os.Stderr
-		p.Stdout = os.Stdout
-		err := p.Start()
-		common.FatalOnError(err)
+	if *export != "" {
+		exportEnvironment(flags, env, fileEnv)
+		return
+	}
 
-		go func(p *exec.Cmd) {
-			p.Wait()
-			if waitingPid != p.Process.Pid {
-				os.Exit(p.ProcessState.ExitCode())
-			}
-		}(p)
+	if len(*command) == 0 {
+		common.Fatalln("required argument 'command' not provided")
 	}
-}
 
-func getParametersByPath(client *ssm.SSM, path string, prefix string) (map[string]string, error) {
-	res, err := client.GetParametersByPath(&ssm.GetParametersByPathInput{
-		Path:           aws.String(path),
-		WithDecryption: aws.Bool(true),
-	})
-	if err != nil {
-		return nil, err
+	if (*refreshAction == "RELOAD_FILES" || *refreshAction == "SIGNAL") && len(*secretFiles) == 0 {
+		common.Fatalln(fmt.Sprintf("--refresh-action %s requires --secret-file", *refreshAction))
 	}
-	result := map[string]string{}
-
-	for _, parameter := range res.Parameters {
-		parts := strings.Split(*parameter.Name, "/")
-		key := strings.Replace(parts[len(parts)-1], "-", "_", -1)
-		key = strings.ToUpper(key)
-		if prefix != "" {
-			key = fmt.Sprintf("%s_%s", prefix, key)
-		}
-		result[key] = *parameter.Value
+	refreshSig, err := parseSignal(*refreshSignal)
+	common.FatalOnErrorW(err, "Invalid --refresh-signal")
+
+	var files *SecretFiles
+	if len(*secretFiles) > 0 {
+		files, err = NewSecretFiles(*secretsDir, *secretFiles)
+		common.FatalOnErrorW(err, "Failed to create the secrets directory")
+		common.RegisterExitHandler(files.Remove)
 	}
 
-	return result, nil
-}
+	session, conf := common.OpenSession(flags)
+	loader := newLoader(env)
 
-func ConvertMap(source map[string]string, prefix string) map[string]string {
-	res := make(map[string]string, len(source))
-	for key, value := range source {
-		var newKey string
-		if prefix == "" {
-			newKey = strings.ToUpper(key)
-		} else {
-			newKey = fmt.Sprintf("%s_%s", prefix, strings.ToUpper(key))
-		}
-		res[newKey] = value
+	var statusHashKey []byte
+	if *statusHashKeyFile != "" {
+		statusHashKey, err = ioutil.ReadFile(*statusHashKeyFile)
+		common.FatalOnErrorW(err, "Failed to read the status hash key")
+		statusHashKey = bytes.TrimRight(statusHashKey, "\r\n")
 	}
-	return res
-}
-
-const (
-	keyLength   = 32
-	nonceLength = 24
-)
-
-type payload struct {
-	Key     []byte
-	Nonce   *[nonceLength]byte
-	Message []byte
-}
-
-func ssmGetParameter(ssmClient *ssm.SSM, name string) (string, error) {
-	res, err := ssmClient.GetParameter(&ssm.GetParameterInput{
-		Name:           aws.String(name),
-		WithDecryption: aws.Bool(true),
-	})
-	if err != nil {
-		return "", err
+	status := NewStatus(*healthMaxFailures, statusHashKey)
+	if *statusAddr != "" {
+		common.FatalOnErrorW(status.Serve(*statusAddr), "Failed to start the status endpoint")
 	}
-	return *res.Parameter.Value, nil
-}
-
-func kmsDecrypt(kmsClient *kms.KMS, ciphertext string) (string, error) {
-	// Decode ciphertext with gob
-	var p payload
-	gob.NewDecoder(bytes.NewReader([]byte(ciphertext))).Decode(&p)
 
-	dataKeyOutput, err := kmsClient.Decrypt(&kms.DecryptInput{
-		CiphertextBlob: p.Key,
-	})
-	if err != nil {
-		return "", err
+	comm := make(chan map[string]string, 1)
+	go Monitor(session, conf, loader, status, comm)
+
+	supervisor := &Supervisor{
+		Command:     *command,
+		StopTimeout: *stopTimeout,
+		OnExit: func(code int) {
+			status.Stopped()
+			common.Exit(code)
+		},
 	}
+	if *reapZombies || os.Getpid() == 1 {
+		supervisor.ReapZombies()
+	}
+	supervisor.ForwardSignals()
 
-	key := &[keyLength]byte{}
-	copy(key[:], dataKeyOutput.Plaintext)
+	started := false
+	var previousEnv map[string]string
 
-	// Decrypt message
-	var plaintext []byte
-	plaintext, ok := secretbox.Open(plaintext, p.Message, p.Nonce, key)
-	if !ok {
-		return "", fmt.Errorf("Failed to open secretbox")
-	}
-	return string(plaintext), nil
-}
+	for envMap := range comm {
+		if envMap == nil {
+			common.Fatalln("Failed to load the environment")
+		}
 
-func secretsManagerGetSecretValue(secretsManagerClient *secretsmanager.SecretsManager, secretName, prefix string) (map[string]string, error) {
-	result, err := secretsManagerClient.GetSecretValue(&secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: secretsManagerVersionStage,
-	})
-	if err != nil {
-		return nil, err
-	}
+		if files != nil {
+			var err error
+			envMap, err = files.Apply(envMap, loader.Config.Static)
+			if err != nil {
+				if !started {
+					common.FatalOnErrorW(err, "Failed to write the secret files")
+				}
+				log.WithError(err).Error("Failed to write the secret files")
+				continue
+			}
+		}
 
-	var content []byte
-	if result.SecretString != nil {
-		content = []byte(*result.SecretString)
-	} else {
-		decodedBinarySecretBytes := make([]byte, base64.StdEncoding.DecodedLen(len(result.SecretBinary)))
-		len, err := base64.StdEncoding.Decode(decodedBinarySecretBytes, result.SecretBinary)
-		if err != nil {
-			return nil, err
+		if started {
+			// the secret files are already rewritten, only restart if the
+			// environment of the command changed
+			reload := *refreshAction == "RELOAD_FILES" || *refreshAction == "SIGNAL"
+			if reload && reflect.DeepEqual(envMap, previousEnv) {
+				if *refreshAction == "SIGNAL" {
+					supervisor.Signal(refreshSig)
+				}
+				log.WithField("action", *refreshAction).Info("Reloaded the secret files")
+				continue
+			}
+
+			supervisor.Stop()
+			status.Stopped()
+			if *refreshAction == "EXIT" {
+				common.Exit(0)
+			}
 		}
-		content = decodedBinarySecretBytes[:len]
-	}
+		previousEnv = envMap
 
-	res := make(map[string]string)
-	err = json.Unmarshal(content, &res)
-	if err != nil {
-		return nil, err
+		err := supervisor.Start(envMap)
+		common.FatalOnError(err)
+		status.Started(supervisor.PID())
+		started = true
 	}
-
-	return ConvertMap(res, prefix), nil
 }
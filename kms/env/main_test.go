@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/hamstah/awstools/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// fakeSecretsManager serves GetSecretValue from secrets by name and stage or version id.
+type fakeSecretsManager struct {
+	secrets map[string]map[string]string
+}
+
+func (f *fakeSecretsManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	input := map[string]string{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	version := input["VersionStage"]
+	if input["VersionId"] != "" {
+		version = input["VersionId"]
+	}
+	value, ok := f.secrets[input["SecretId"]][version]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"__type": "ResourceNotFoundException", "message": "not found"}`)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"Name": input["SecretId"], "SecretString": value})
+}
+
+func TestLoaderSecretsManagerPending(t *testing.T) {
+	server := httptest.NewServer(&fakeSecretsManager{secrets: map[string]map[string]string{
+		"db": {
+			"AWSCURRENT":                           `{"password": "current"}`,
+			"AWSPENDING":                           `{"password": "pending"}`,
+			"AWSPREVIOUS":                          `{"password": "previous"}`,
+			"6d1a4a5e-3f2b-4c8e-9a7d-0b1c2d3e4f50": `{"password": "pinned"}`,
+		},
+		"api": {"AWSCURRENT": `{"token": "token"}`},
+	}})
+	defer server.Close()
+
+	_, err := kingpin.CommandLine.Parse([]string{"--secrets-manager-pending", "--refresh-max-retries", "1"})
+	require.NoError(t, err)
+	defer func() { *secretsManagerPending = false }()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+
+	loader := newLoader([]string{
+		"SECRETS_MANAGER_DB=db",
+		"SECRETS_MANAGER__API=api",
+		"PREVIOUS=secrets-manager://db@AWSPREVIOUS#password",
+		"PINNED=secrets-manager://db?version=6d1a4a5e-3f2b-4c8e-9a7d-0b1c2d3e4f50#password",
+	})
+	env, err := loader.Refresh(sess, &aws.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_PASSWORD":         "current",
+		"DB_PASSWORD_PENDING": "pending",
+		"TOKEN":               "token",
+		"TOKEN_PENDING":       "token",
+		"PREVIOUS":            "previous",
+		"PREVIOUS_PENDING":    "previous",
+		"PINNED":              "pinned",
+		"PINNED_PENDING":      "pinned",
+	}, env)
+}
+
+func TestConfigValuesFileValues(t *testing.T) {
+	env := []string{"DATABASE_URL=file:///var/db.sqlite", "PASSWORD=ssm:///db/password", "BUCKET=s3://bucket/key"}
+
+	_, err := kingpin.CommandLine.Parse([]string{})
+	require.NoError(t, err)
+	c := configValues(env)
+	assert.Equal(t, "file:///var/db.sqlite", c.Static["DATABASE_URL"])
+	assert.Equal(t, "s3://bucket/key", c.Static["BUCKET"])
+	assert.Equal(t, common.Source{Type: "SSM", Name: "PASSWORD", Identifier: "/db/password"}, c.Static["PASSWORD"])
+
+	_, err = kingpin.CommandLine.Parse([]string{"--file-values"})
+	require.NoError(t, err)
+	defer func() { *fileValues = false }()
+	c = configValues(env)
+	assert.Equal(t, common.Source{Type: "FILE", Name: "DATABASE_URL", Identifier: "/var/db.sqlite"}, c.Static["DATABASE_URL"])
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+}
+
+// forwardedSignals are sent to the command when kms-env receives them.
+var forwardedSignals = []os.Signal{
+	syscall.SIGHUP,
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGTERM,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+}
+
+// parseSignal accepts names like SIGHUP or HUP and numbers.
+func parseSignal(value string) (syscall.Signal, error) {
+	if number, err := strconv.Atoi(value); err == nil {
+		return syscall.Signal(number), nil
+	}
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(value), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("Unknown signal %s", value)
+	}
+	return sig, nil
+}
+
+// exitCode follows the shell convention of 128 + the signal number for
+// processes killed by a signal.
+func exitCode(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// Supervisor runs the command, restarts it and forwards signals to it.
+// OnExit is called with the exit code when the command exits without being
+// stopped by the supervisor.
+type Supervisor struct {
+	Command     []string
+	StopTimeout time.Duration
+	OnExit      func(code int)
+
+	m           sync.Mutex
+	cmd         *exec.Cmd
+	exited      chan struct{}
+	stopping    bool
+	terminating bool
+	reap        bool
+	waiters     map[int]chan syscall.WaitStatus
+}
+
+// Running returns whether the command was started and has not exited.
+func (s *Supervisor) Running() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.cmd != nil
+}
+
+// PID returns the pid of the command, or 0 if it is not running.
+func (s *Supervisor) PID() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cmd == nil {
+		return 0
+	}
+	return s.cmd.Process.Pid
+}
+
+// Start runs the command with env, the previous command must be stopped.
+func (s *Supervisor) Start(env map[string]string) error {
+	var cmdEnv []string
+	for key, value := range env {
+		cmdEnv = append(cmdEnv, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	cmd := exec.Command(s.Command[0], s.Command[1:]...)
+	cmd.Env = cmdEnv
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+
+	// hold the lock until the waiter is registered so the reaper cannot
+	// miss the command if it exits immediately
+	s.m.Lock()
+	defer s.m.Unlock()
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	s.cmd = cmd
+	s.exited = make(chan struct{})
+	s.stopping = false
+	s.terminating = false
+
+	var statuses chan syscall.WaitStatus
+	if s.reap {
+		statuses = make(chan syscall.WaitStatus, 1)
+		s.waiters[cmd.Process.Pid] = statuses
+	}
+	go s.wait(cmd, s.exited, statuses)
+	return nil
+}
+
+func (s *Supervisor) wait(cmd *exec.Cmd, exited chan struct{}, statuses chan syscall.WaitStatus) {
+	var status syscall.WaitStatus
+	if statuses != nil {
+		status = <-statuses
+	} else {
+		for {
+			_, err := syscall.Wait4(cmd.Process.Pid, &status, 0, nil)
+			if err != syscall.EINTR {
+				break
+			}
+		}
+	}
+
+	s.m.Lock()
+	stopping := s.stopping
+	if s.cmd == cmd {
+		s.cmd = nil
+	}
+	s.m.Unlock()
+	close(exited)
+
+	code := exitCode(status)
+	log.WithFields(log.Fields{"pid": cmd.Process.Pid, "code": code}).Debug("Command exited")
+	if !stopping && s.OnExit != nil {
+		s.OnExit(code)
+	}
+}
+
+// Signal sends sig to the command if it is running.
+func (s *Supervisor) Signal(sig os.Signal) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cmd != nil {
+		s.cmd.Process.Signal(sig)
+	}
+}
+
+// Terminate sends sig to the command and SIGKILL if it is still running
+// after the stop timeout. OnExit is still called when it exits.
+func (s *Supervisor) Terminate(sig os.Signal) {
+	s.terminate(sig, true)
+}
+
+// terminate only sends sig if send is set. A single stop timeout runs at a
+// time, later calls wait for the command to exit.
+func (s *Supervisor) terminate(sig os.Signal, send bool) {
+	s.m.Lock()
+	cmd, exited, terminating := s.cmd, s.exited, s.terminating
+	s.terminating = true
+	s.m.Unlock()
+	if cmd == nil {
+		return
+	}
+
+	if send {
+		cmd.Process.Signal(sig)
+	}
+	if terminating || s.StopTimeout == 0 {
+		<-exited
+		return
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(s.StopTimeout):
+		log.WithField("pid", cmd.Process.Pid).Warn("Command did not stop in time, killing it")
+		cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// Stop terminates the command without calling OnExit.
+func (s *Supervisor) Stop() {
+	s.m.Lock()
+	s.stopping = true
+	s.m.Unlock()
+	s.Terminate(syscall.SIGTERM)
+}
+
+// sentByTerminal returns whether sig was most likely sent by the terminal
+// to its foreground process group, which the command shares with kms-env so
+// it already received sig.
+func (s *Supervisor) sentByTerminal(sig os.Signal) bool {
+	if sig != syscall.SIGINT && sig != syscall.SIGQUIT {
+		return false
+	}
+	pid := s.PID()
+	if pid == 0 {
+		return false
+	}
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil || pgid != syscall.Getpgrp() {
+		return false
+	}
+	foreground, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil && foreground == pgid
+}
+
+// ForwardSignals sends the signals received by kms-env to the command,
+// except SIGINT and SIGQUIT from the terminal. SIGINT and SIGTERM use
+// Terminate, or call OnExit if the command is not running yet.
+func (s *Supervisor) ForwardSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	go func() {
+		for sig := range signals {
+			send := !s.sentByTerminal(sig)
+			if sig != syscall.SIGINT && sig != syscall.SIGTERM {
+				if send {
+					s.Signal(sig)
+				}
+				continue
+			}
+			if !s.Running() && s.OnExit != nil {
+				s.OnExit(128 + int(sig.(syscall.Signal)))
+				continue
+			}
+			go s.terminate(sig, send)
+		}
+	}()
+}
+
+// ReapZombies waits for all the child processes, including orphans adopted
+// when kms-env runs as PID 1, and passes the status of the command to its
+// waiter. It must be called before Start.
+func (s *Supervisor) ReapZombies() {
+	s.reap = true
+	s.waiters = map[int]chan syscall.WaitStatus{}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGCHLD)
+
+	go func() {
+		for range signals {
+			for {
+				var status syscall.WaitStatus
+				pid, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+				if err == syscall.EINTR {
+					continue
+				}
+				if err != nil || pid <= 0 {
+					break
+				}
+
+				s.m.Lock()
+				waiter, ok := s.waiters[pid]
+				delete(s.waiters, pid)
+				s.m.Unlock()
+
+				if ok {
+					waiter <- status
+				} else {
+					log.WithField("pid", pid).Debug("Reaped zombie process")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSignal(t *testing.T) {
+	for _, value := range []string{"SIGHUP", "HUP", "hup", "1"} {
+		sig, err := parseSignal(value)
+		require.NoError(t, err)
+		assert.Equal(t, syscall.SIGHUP, sig)
+	}
+
+	_, err := parseSignal("SIGNOPE")
+	assert.Error(t, err)
+}
+
+func newTestSupervisor(command ...string) (*Supervisor, chan int) {
+	codes := make(chan int, 1)
+	return &Supervisor{
+		Command:     command,
+		StopTimeout: time.Second,
+		OnExit:      func(code int) { codes <- code },
+	}, codes
+}
+
+func waitCode(t *testing.T, codes chan int) int {
+	select {
+	case code := <-codes:
+		return code
+	case <-time.After(5 * time.Second):
+		t.Fatal("command did not exit")
+	}
+	return 0
+}
+
+func TestSupervisorExitCode(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sh", "-c", "exit $CODE")
+	require.NoError(t, supervisor.Start(map[string]string{"CODE": "3"}))
+	assert.Equal(t, 3, waitCode(t, codes))
+	assert.False(t, supervisor.Running())
+}
+
+func TestSupervisorStop(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sh", "-c", "trap '' TERM; while true; do sleep 0.1; done")
+	require.NoError(t, supervisor.Start(nil))
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	supervisor.Stop()
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.False(t, supervisor.Running())
+
+	select {
+	case code := <-codes:
+		t.Fatalf("OnExit called with %d after Stop", code)
+	default:
+	}
+}
+
+func TestSupervisorTerminate(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sleep", "10")
+	require.NoError(t, supervisor.Start(nil))
+
+	supervisor.Terminate(syscall.SIGINT)
+	assert.Equal(t, 128+int(syscall.SIGINT), waitCode(t, codes))
+}
+
+func TestSupervisorTerminateWithoutSignal(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sh", "-c", "trap 'exit 3' TERM; while true; do sleep 0.1; done")
+	supervisor.StopTimeout = 200 * time.Millisecond
+	require.NoError(t, supervisor.Start(nil))
+	time.Sleep(100 * time.Millisecond)
+
+	supervisor.terminate(syscall.SIGTERM, false)
+	assert.Equal(t, 128+int(syscall.SIGKILL), waitCode(t, codes))
+}
+
+func TestSupervisorTerminateOnce(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sh", "-c", "trap '' TERM; while true; do sleep 0.1; done")
+	supervisor.StopTimeout = 300 * time.Millisecond
+	require.NoError(t, supervisor.Start(nil))
+	time.Sleep(100 * time.Millisecond)
+
+	go supervisor.Terminate(syscall.SIGTERM)
+	time.Sleep(100 * time.Millisecond)
+	supervisor.m.Lock()
+	terminating := supervisor.terminating
+	supervisor.m.Unlock()
+	assert.True(t, terminating)
+
+	supervisor.Terminate(syscall.SIGTERM)
+	assert.False(t, supervisor.Running())
+	assert.Equal(t, 128+int(syscall.SIGKILL), waitCode(t, codes))
+}
+
+func TestSupervisorSentByTerminal(t *testing.T) {
+	supervisor, _ := newTestSupervisor("sleep", "10")
+	assert.False(t, supervisor.sentByTerminal(syscall.SIGINT))
+	require.NoError(t, supervisor.Start(nil))
+	defer supervisor.Stop()
+
+	assert.False(t, supervisor.sentByTerminal(syscall.SIGTERM))
+	assert.False(t, supervisor.sentByTerminal(syscall.SIGHUP))
+}
+
+func TestSupervisorReapZombies(t *testing.T) {
+	supervisor, codes := newTestSupervisor("sh", "-c", "exit 4")
+	supervisor.ReapZombies()
+	require.NoError(t, supervisor.Start(nil))
+	assert.Equal(t, 4, waitCode(t, codes))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is reported by the status endpoint, see --status-addr.
+type Status struct {
+	StartedAt           time.Time  `json:"started_at"`
+	LastRefresh         *time.Time `json:"last_refresh,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	Failures            int        `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	PID                 int        `json:"pid,omitempty"`
+	Restarts            int        `json:"restarts"`
+	EnvironmentHash     string     `json:"environment_hash,omitempty"`
+
+	// MaxFailures is the number of consecutive refresh failures after which
+	// the status is unhealthy, the command keeps the previous values before.
+	MaxFailures int `json:"-"`
+
+	m       sync.Mutex
+	key     []byte
+	started bool
+}
+
+// NewStatus uses key for the environment hash so it cannot be used to guess
+// values. Without a key one is generated and the hash changes every time
+// kms-env starts.
+func NewStatus(maxFailures int, key []byte) *Status {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	return &Status{StartedAt: time.Now(), MaxFailures: maxFailures, key: key}
+}
+
+func (s *Status) hash(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mac := hmac.New(sha256.New, s.key)
+	for _, key := range keys {
+		mac.Write([]byte(key))
+		mac.Write([]byte{0})
+		mac.Write([]byte(env[key]))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Status) Refreshed(env map[string]string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	s.LastRefresh = &now
+	s.ConsecutiveFailures = 0
+	s.EnvironmentHash = s.hash(env)
+}
+
+func (s *Status) Failed(err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	s.LastError = err.Error()
+	s.LastErrorAt = &now
+	s.Failures++
+	s.ConsecutiveFailures++
+}
+
+// Started records the pid of the command, restarts are counted after the
+// first start.
+func (s *Status) Started(pid int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.started {
+		s.Restarts++
+	}
+	s.started = true
+	s.PID = pid
+}
+
+// Stopped clears the pid when the command exits.
+func (s *Status) Stopped() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.PID = 0
+}
+
+// Healthy returns whether the command runs with loaded values and refreshes
+// did not fail MaxFailures times in a row.
+func (s *Status) Healthy() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.PID != 0 && s.LastRefresh != nil && s.ConsecutiveFailures < s.MaxFailures
+}
+
+// ServeHTTP reports the status on /status, and 200 or 503 on /health.
+func (s *Status) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/status":
+		s.m.Lock()
+		data, err := json.MarshalIndent(s, "", "  ")
+		s.m.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(append(data, '\n'))
+	case "/health":
+		if !s.Healthy() {
+			http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// statusAddress listens on 127.0.0.1 when addr has no host, the endpoint is
+// not authenticated.
+func statusAddress(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// Serve listens on addr before returning so errors are reported on start.
+func (s *Status) Serve(addr string) error {
+	listener, err := net.Listen("tcp", statusAddress(addr))
+	if err != nil {
+		return err
+	}
+	go http.Serve(listener, s)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getStatus(t *testing.T, status *Status, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	status.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder
+}
+
+func TestStatus(t *testing.T) {
+	status := NewStatus(2, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, getStatus(t, status, "/health").Code)
+
+	status.Refreshed(map[string]string{"PASSWORD": "secret"})
+	status.Started(10)
+	assert.Equal(t, http.StatusOK, getStatus(t, status, "/health").Code)
+	hash := status.EnvironmentHash
+	assert.NotContains(t, hash, "secret")
+
+	status.Failed(errors.New("throttled"))
+	assert.Equal(t, http.StatusOK, getStatus(t, status, "/health").Code)
+	status.Failed(errors.New("throttled"))
+	assert.Equal(t, http.StatusServiceUnavailable, getStatus(t, status, "/health").Code)
+
+	status.Refreshed(map[string]string{"PASSWORD": "rotated"})
+	status.Stopped()
+	assert.Equal(t, 0, status.PID)
+	assert.Equal(t, http.StatusServiceUnavailable, getStatus(t, status, "/health").Code)
+	status.Started(11)
+	assert.Equal(t, http.StatusOK, getStatus(t, status, "/health").Code)
+	assert.NotEqual(t, hash, status.EnvironmentHash)
+
+	recorder := getStatus(t, status, "/status")
+	require.Equal(t, http.StatusOK, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), "rotated")
+
+	report := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &report))
+	assert.Equal(t, float64(11), report["pid"])
+	assert.Equal(t, float64(1), report["restarts"])
+	assert.Equal(t, float64(2), report["failures"])
+	assert.Equal(t, float64(0), report["consecutive_failures"])
+	assert.Equal(t, "throttled", report["last_error"])
+	assert.NotEmpty(t, report["last_refresh"])
+
+	assert.Equal(t, http.StatusNotFound, getStatus(t, status, "/").Code)
+	assert.NotEqual(t, hash, NewStatus(2, nil).hash(map[string]string{"PASSWORD": "secret"}))
+}
+
+func TestStatusHashKey(t *testing.T) {
+	env := map[string]string{"PASSWORD": "secret"}
+	key := []byte("shared")
+	assert.Equal(t, NewStatus(2, key).hash(env), NewStatus(2, key).hash(env))
+	assert.NotEqual(t, NewStatus(2, key).hash(env), NewStatus(2, []byte("other")).hash(env))
+}
+
+func TestStatusAddress(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:8080", statusAddress(":8080"))
+	assert.Equal(t, "0.0.0.0:8080", statusAddress("0.0.0.0:8080"))
+	assert.Equal(t, "[::1]:8080", statusAddress("[::1]:8080"))
+	assert.Equal(t, "localhost:8080", statusAddress("localhost:8080"))
+}
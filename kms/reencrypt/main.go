@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/hamstah/awstools/common"
+	log "github.com/sirupsen/logrus"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	targetKeyID    = kingpin.Flag("target-key-id", "KMS key id, ARN or alias to re-encrypt with").Required().String()
+	configFiles    = kingpin.Flag("config-file", "JSON config file in the ConfigValues format, can be repeated").Strings()
+	envFiles       = kingpin.Flag("env-file", "Env file with KEY=VALUE lines, can be repeated").Strings()
+	ssmParameters  = kingpin.Flag("ssm-parameter", "Name of an SSM parameter containing a ciphertext, can be repeated").Strings()
+	kmsPrefix      = kingpin.Flag("kms-prefix", "Prefix of the keys with KMS values").Default("KMS_").String()
+	kmsValuePrefix = kingpin.Flag("kms-value-prefix", "Prefix of the KMS values").Default("kms://").String()
+	dryRun         = kingpin.Flag("dry-run", "Print the changes without writing them").Bool()
+)
+
+type Change struct {
+	Location    string
+	SourceKeyID string
+	Old         string
+	New         string
+}
+
+// Write saves the changes of a file or an SSM parameter, writes are only
+// applied once every value is re-encrypted.
+type Write struct {
+	Location string
+	Changes  []*Change
+	Apply    func() error
+}
+
+type ReEncrypter struct {
+	KMSClient *kms.KMS
+	Changes   []*Change
+	Writes    []*Write
+}
+
+// ReEncrypt re-encrypts value, keeping its prefix.
+func (r *ReEncrypter) ReEncrypt(location, value, prefix string) (string, error) {
+	ciphertext := strings.TrimPrefix(value, prefix)
+
+	res, sourceKeyID, err := common.ReEncryptWithKMS(r.KMSClient, ciphertext, *targetKeyID)
+	if err != nil {
+		return "", fmt.Errorf("Failed to re-encrypt %s: %s", location, err)
+	}
+
+	res = prefix + res
+	r.Changes = append(r.Changes, &Change{
+		Location:    location,
+		SourceKeyID: sourceKeyID,
+		Old:         value,
+		New:         res,
+	})
+	return res, nil
+}
+
+// addWrite queues apply with the changes made since count, it is skipped
+// without changes or with --dry-run.
+func (r *ReEncrypter) addWrite(location string, count int, apply func() error) {
+	if *dryRun || count == len(r.Changes) {
+		return
+	}
+	r.Writes = append(r.Writes, &Write{
+		Location: location,
+		Changes:  r.Changes[count:],
+		Apply:    apply,
+	})
+}
+
+// Apply runs the writes in order and returns the changes written before
+// the first error.
+func (r *ReEncrypter) Apply() ([]*Change, error) {
+	applied := []*Change{}
+	for _, write := range r.Writes {
+		err := write.Apply()
+		if err != nil {
+			return applied, fmt.Errorf("Failed to write %s: %s", write.Location, err)
+		}
+		applied = append(applied, write.Changes...)
+	}
+	return applied, nil
+}
+
+// ReEncryptJSON replaces KMS values in keys with the KMS prefix and values with the KMS value prefix.
+func (r *ReEncrypter) ReEncryptJSON(location string, key string, value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			res, err := r.ReEncryptJSON(fmt.Sprintf("%s.%s", location, k), k, v)
+			if err != nil {
+				return nil, err
+			}
+			value[k] = res
+		}
+	case []interface{}:
+		for i, v := range value {
+			res, err := r.ReEncryptJSON(fmt.Sprintf("%s[%d]", location, i), "", v)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = res
+		}
+	case string:
+		if strings.HasPrefix(value, *kmsValuePrefix) {
+			return r.ReEncrypt(location, value, *kmsValuePrefix)
+		}
+		if key != "" && strings.HasPrefix(key, *kmsPrefix) {
+			return r.ReEncrypt(location, value, "")
+		}
+		return r.ReEncryptTemplate(location, value)
+	}
+	return value, nil
+}
+
+// ReEncryptTemplate replaces the ciphertexts of ${kms:...} references in
+// templates, $${ is a literal ${ like in ConfigValues templates.
+func (r *ReEncrypter) ReEncryptTemplate(location, value string) (string, error) {
+	start := "${" + strings.TrimSuffix(*kmsValuePrefix, "://") + ":"
+	res := strings.Builder{}
+	rest := value
+	for {
+		i := strings.Index(rest, start)
+		end := -1
+		if i >= 0 {
+			end = strings.Index(rest[i:], "}")
+		}
+		if end < 0 {
+			res.WriteString(rest)
+			return res.String(), nil
+		}
+
+		ciphertext := rest[i+len(start) : i+end]
+		if (i > 0 && rest[i-1] == '$') || ciphertext == "" {
+			res.WriteString(rest[:i+end+1])
+			rest = rest[i+end+1:]
+			continue
+		}
+
+		newCiphertext, err := r.ReEncrypt(location, ciphertext, "")
+		if err != nil {
+			return "", err
+		}
+		res.WriteString(rest[:i+len(start)])
+		res.WriteString(newCiphertext)
+		res.WriteString("}")
+		rest = rest[i+end+1:]
+	}
+}
+
+// ReEncryptConfigFile only replaces the ciphertexts in the content of the
+// file to keep its formatting, the order of the keys and the numbers.
+func (r *ReEncrypter) ReEncryptConfigFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var config interface{}
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return err
+	}
+
+	count := len(r.Changes)
+	_, err = r.ReEncryptJSON(filename, "", config)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range r.Changes[count:] {
+		if !bytes.Contains(data, []byte(change.Old)) {
+			return fmt.Errorf("Failed to find the ciphertext of %s, it cannot contain JSON escapes", change.Location)
+		}
+		data = bytes.Replace(data, []byte(change.Old), []byte(change.New), 1)
+	}
+	r.addWrite(filename, count, func() error {
+		return writeFile(filename, data)
+	})
+	return nil
+}
+
+// ReEncryptEnvFile keeps comments, export and quotes.
+func (r *ReEncrypter) ReEncryptEnvFile(filename string) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	count := len(r.Changes)
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(parts[0]), "export "))
+		value := parts[1]
+
+		quote := ""
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			quote = value[:1]
+			value = value[1 : len(value)-1]
+		}
+
+		prefix := ""
+		if strings.HasPrefix(value, *kmsValuePrefix) {
+			prefix = *kmsValuePrefix
+		} else if !strings.HasPrefix(key, *kmsPrefix) {
+			continue
+		}
+
+		res, err := r.ReEncrypt(fmt.Sprintf("%s:%s", filename, key), value, prefix)
+		if err != nil {
+			return err
+		}
+		lines[i] = fmt.Sprintf("%s=%s%s%s", parts[0], quote, res, quote)
+	}
+
+	r.addWrite(filename, count, func() error {
+		return writeFile(filename, []byte(strings.Join(lines, "\n")))
+	})
+	return nil
+}
+
+// ReEncryptSSMParameter keeps the key, tier, allowed pattern, description,
+// data type and policies of the parameter.
+func (r *ReEncrypter) ReEncryptSSMParameter(ssmClient *ssm.SSM, name string) error {
+	res, err := ssmClient.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	described, err := ssmClient.DescribeParameters(&ssm.DescribeParametersInput{
+		ParameterFilters: []*ssm.ParameterStringFilter{{
+			Key:    aws.String("Name"),
+			Option: aws.String("Equals"),
+			Values: []*string{res.Parameter.Name},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(described.Parameters) != 1 {
+		return fmt.Errorf("Failed to describe the parameter %s", name)
+	}
+	metadata := described.Parameters[0]
+
+	value := aws.StringValue(res.Parameter.Value)
+	prefix := ""
+	if strings.HasPrefix(value, *kmsValuePrefix) {
+		prefix = *kmsValuePrefix
+	}
+
+	count := len(r.Changes)
+	newValue, err := r.ReEncrypt(name, value, prefix)
+	if err != nil {
+		return err
+	}
+
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Type:      res.Parameter.Type,
+		Value:     aws.String(newValue),
+		Overwrite: aws.Bool(true),
+		KeyId:     metadata.KeyId,
+		Tier:      metadata.Tier,
+		DataType:  metadata.DataType,
+	}
+	if aws.StringValue(metadata.AllowedPattern) != "" {
+		input.AllowedPattern = metadata.AllowedPattern
+	}
+	if aws.StringValue(metadata.Description) != "" {
+		input.Description = metadata.Description
+	}
+	if len(metadata.Policies) > 0 {
+		policies := []string{}
+		for _, policy := range metadata.Policies {
+			policies = append(policies, aws.StringValue(policy.PolicyText))
+		}
+		input.Policies = aws.String("[" + strings.Join(policies, ",") + "]")
+	}
+
+	r.addWrite(name, count, func() error {
+		_, err := ssmClient.PutParameter(input)
+		return err
+	})
+	return nil
+}
+
+// writeFile replaces the file atomically and keeps the mode of the existing
+// file, an interrupted write never leaves a truncated config.
+func writeFile(filename string, data []byte) error {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(info.Mode())
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// fingerprint identifies a ciphertext in the diff, the ciphertexts of envelopes
+// mostly differ in the middle.
+func fingerprint(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return fmt.Sprintf("sha256:%x", sum[:8])
+}
+
+func printChanges(changes []*Change) {
+	for _, change := range changes {
+		fmt.Printf("--- %s\n", change.Location)
+		fmt.Printf("-%s %s\n", change.SourceKeyID, fingerprint(change.Old))
+		fmt.Printf("+%s %s\n", *targetKeyID, fingerprint(change.New))
+	}
+}
+
+func main() {
+	kingpin.CommandLine.Name = "kms-reencrypt"
+	kingpin.CommandLine.Help = "Re-encrypt KMS values of config files, env files and SSM parameters with another key."
+	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
+
+	if len(*configFiles) == 0 && len(*envFiles) == 0 && len(*ssmParameters) == 0 {
+		common.Fatalln("Set at least one of --config-file, --env-file or --ssm-parameter")
+	}
+
+	session, conf := common.OpenSession(flags)
+	r := &ReEncrypter{KMSClient: kms.New(session, conf)}
+
+	for _, filename := range *configFiles {
+		common.FatalOnErrorW(r.ReEncryptConfigFile(filename), filename)
+	}
+
+	for _, filename := range *envFiles {
+		common.FatalOnErrorW(r.ReEncryptEnvFile(filename), filename)
+	}
+
+	if len(*ssmParameters) > 0 {
+		ssmClient := ssm.New(session, conf)
+		for _, name := range *ssmParameters {
+			common.FatalOnErrorW(r.ReEncryptSSMParameter(ssmClient, name), name)
+		}
+	}
+
+	if *dryRun {
+		printChanges(r.Changes)
+		return
+	}
+
+	// Only the changes written before a failure are printed, the other
+	// values still use the previous key.
+	applied, err := r.Apply()
+	printChanges(applied)
+	common.FatalOnError(err)
+	log.WithField("count", len(applied)).Info("Re-encrypted values")
+}
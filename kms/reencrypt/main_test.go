@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+)
+
+// fakeKMS serves ReEncrypt for blobs wrapping the key id and the plaintext
+// in JSON, like the fake KMS of common.
+type fakeKMS struct{}
+
+type fakeKMSBlob struct {
+	KeyID     string
+	Plaintext string
+}
+
+func fakeCiphertext(keyID, plaintext string) string {
+	data, _ := json.Marshal(fakeKMSBlob{KeyID: keyID, Plaintext: plaintext})
+	return base64.StdEncoding.EncodeToString(append([]byte("blob:"), data...))
+}
+
+func fakeCiphertextBlob(t *testing.T, ciphertext string) fakeKMSBlob {
+	data, err := base64.StdEncoding.DecodeString(ciphertext)
+	require.NoError(t, err)
+	blob := fakeKMSBlob{}
+	require.NoError(t, json.Unmarshal(bytes.TrimPrefix(data, []byte("blob:")), &blob))
+	return blob
+}
+
+func (f fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		CiphertextBlob   []byte
+		DestinationKeyId string
+	}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	blob := fakeKMSBlob{}
+	json.Unmarshal(bytes.TrimPrefix(input.CiphertextBlob, []byte("blob:")), &blob)
+	data, _ := json.Marshal(fakeKMSBlob{KeyID: input.DestinationKeyId, Plaintext: blob.Plaintext})
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"SourceKeyId":    blob.KeyID,
+		"KeyId":          input.DestinationKeyId,
+		"CiphertextBlob": append([]byte("blob:"), data...),
+	})
+}
+
+func newReEncrypter(t *testing.T, args ...string) (*ReEncrypter, *httptest.Server) {
+	_, err := kingpin.CommandLine.Parse(append([]string{"--target-key-id", "target"}, args...))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(fakeKMS{})
+	return &ReEncrypter{KMSClient: kms.New(newSession(t, server.URL))}, server
+}
+
+func newSession(t *testing.T, endpoint string) *session.Session {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("eu-west-1"),
+		Endpoint:    aws.String(endpoint),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	require.NoError(t, err)
+	return sess
+}
+
+// fakeSSM serves a single advanced SecureString parameter and records the
+// input of PutParameter.
+type fakeSSM struct {
+	Value string
+	Put   map[string]interface{}
+}
+
+func (f *fakeSSM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	input := map[string]interface{}{}
+	json.NewDecoder(r.Body).Decode(&input)
+
+	var output interface{}
+	switch r.Header.Get("X-Amz-Target") {
+	case "AmazonSSM.GetParameter":
+		output = map[string]interface{}{"Parameter": map[string]interface{}{
+			"Name": "/app/key", "Type": "SecureString", "Value": f.Value,
+		}}
+	case "AmazonSSM.DescribeParameters":
+		filter := input["ParameterFilters"].([]interface{})[0].(map[string]interface{})
+		if filter["Key"] != "Name" || filter["Values"].([]interface{})[0] != "/app/key" {
+			output = map[string]interface{}{"Parameters": []interface{}{}}
+			break
+		}
+		output = map[string]interface{}{"Parameters": []interface{}{map[string]interface{}{
+			"Name":           "/app/key",
+			"Type":           "SecureString",
+			"KeyId":          "alias/app",
+			"Tier":           "Advanced",
+			"AllowedPattern": "^kms://.*",
+			"Description":    "Private key",
+			"DataType":       "text",
+			"Policies":       []interface{}{map[string]interface{}{"PolicyText": `{"Type":"ExpirationNotification"}`}},
+		}}}
+	case "AmazonSSM.PutParameter":
+		f.Put = input
+		output = map[string]interface{}{"Version": 2}
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	json.NewEncoder(w).Encode(output)
+}
+
+func writeTempFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "kms-reencrypt")
+	require.NoError(t, err)
+	filename := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(filename, []byte(content), 0640))
+	return filename
+}
+
+func readFile(t *testing.T, filename string) string {
+	data, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestReEncryptConfigFile(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	filename := writeTempFile(t, "config.json", `{
+  "name": "app",
+  "db": {
+    "KMS_PASSWORD": "`+fakeCiphertext("old", "password")+`",
+    "hosts": ["kms://`+fakeCiphertext("old", "host")+`", "plain"]
+  }
+}`)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	require.NoError(t, r.ReEncryptConfigFile(filename))
+	require.Len(t, r.Changes, 2)
+	_, err := r.Apply()
+	require.NoError(t, err)
+
+	config := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(readFile(t, filename)), &config))
+	db := config["db"].(map[string]interface{})
+	assert.Equal(t, "app", config["name"])
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "password"}, fakeCiphertextBlob(t, db["KMS_PASSWORD"].(string)))
+
+	hosts := db["hosts"].([]interface{})
+	require.True(t, strings.HasPrefix(hosts[0].(string), "kms://"))
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "host"}, fakeCiphertextBlob(t, strings.TrimPrefix(hosts[0].(string), "kms://")))
+	assert.Equal(t, "plain", hosts[1])
+
+	for _, change := range r.Changes {
+		assert.Equal(t, "old", change.SourceKeyID)
+	}
+}
+
+func TestReEncryptConfigFileKeepsFormatting(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	password := fakeCiphertext("old", "password")
+	token := fakeCiphertext("old", "token")
+	content := `{
+    "name": "<app & co>",
+    "account_id": 123456789012,
+    "ratio": 1.50,
+    "KMS_PASSWORD": "%s",
+    "url": "postgres://app:${kms:%s}@db/$${kms:literal}?sslmode=${ssm:/db/ssl}"
+}
+`
+	filename := writeTempFile(t, "config.json", fmt.Sprintf(content, password, token))
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	require.NoError(t, r.ReEncryptConfigFile(filename))
+	require.Len(t, r.Changes, 2)
+	_, err := r.Apply()
+	require.NoError(t, err)
+
+	changes := map[string]*Change{}
+	for _, change := range r.Changes {
+		changes[change.Location] = change
+	}
+	newPassword := changes[filename+".KMS_PASSWORD"].New
+	newToken := changes[filename+".url"].New
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "password"}, fakeCiphertextBlob(t, newPassword))
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "token"}, fakeCiphertextBlob(t, newToken))
+	assert.Equal(t, fmt.Sprintf(content, newPassword, newToken), readFile(t, filename))
+}
+
+func TestReEncryptEnvFile(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	token := fakeCiphertext("old", "token")
+	password := fakeCiphertext("old", "password")
+	content := strings.Join([]string{
+		"# KMS_COMMENTED=" + token,
+		"",
+		`export KMS_TOKEN="` + token + `"`,
+		"PASSWORD='kms://" + password + "'",
+		"NAME=app",
+		"",
+	}, "\n")
+	filename := writeTempFile(t, ".env", content)
+	defer os.RemoveAll(filepath.Dir(filename))
+
+	require.NoError(t, r.ReEncryptEnvFile(filename))
+	require.Len(t, r.Changes, 2)
+	applied, err := r.Apply()
+	require.NoError(t, err)
+	assert.Equal(t, r.Changes, applied)
+
+	newToken, newPassword := r.Changes[0].New, strings.TrimPrefix(r.Changes[1].New, "kms://")
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "token"}, fakeCiphertextBlob(t, newToken))
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "password"}, fakeCiphertextBlob(t, newPassword))
+
+	assert.Equal(t, strings.Join([]string{
+		"# KMS_COMMENTED=" + token,
+		"",
+		`export KMS_TOKEN="` + newToken + `"`,
+		"PASSWORD='kms://" + newPassword + "'",
+		"NAME=app",
+		"",
+	}, "\n"), readFile(t, filename))
+
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode())
+	files, err := ioutil.ReadDir(filepath.Dir(filename))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestReEncryptDryRun(t *testing.T) {
+	r, server := newReEncrypter(t, "--dry-run")
+	defer server.Close()
+	defer func() { *dryRun = false }()
+
+	envContent := "KMS_TOKEN=" + fakeCiphertext("old", "token") + "\n"
+	envFile := writeTempFile(t, ".env", envContent)
+	defer os.RemoveAll(filepath.Dir(envFile))
+	configContent := `{"KMS_TOKEN": "` + fakeCiphertext("old", "token") + `"}`
+	configFile := writeTempFile(t, "config.json", configContent)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	require.NoError(t, r.ReEncryptEnvFile(envFile))
+	require.NoError(t, r.ReEncryptConfigFile(configFile))
+	assert.Len(t, r.Changes, 2)
+	assert.Empty(t, r.Writes)
+	assert.Equal(t, envContent, readFile(t, envFile))
+	assert.Equal(t, configContent, readFile(t, configFile))
+}
+
+func TestReEncryptWithoutCiphertexts(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	envContent := "# comment\nexport NAME='app'\nURL=https://example.com/?a=b"
+	envFile := writeTempFile(t, ".env", envContent)
+	defer os.RemoveAll(filepath.Dir(envFile))
+	configContent := `{"name":"app","list":[1,2]}`
+	configFile := writeTempFile(t, "config.json", configContent)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	require.NoError(t, r.ReEncryptEnvFile(envFile))
+	require.NoError(t, r.ReEncryptConfigFile(configFile))
+	assert.Empty(t, r.Changes)
+	assert.Empty(t, r.Writes)
+	assert.Equal(t, envContent, readFile(t, envFile))
+	assert.Equal(t, configContent, readFile(t, configFile))
+}
+
+func TestReEncryptWritesAfterEveryValue(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	envContent := "KMS_TOKEN=" + fakeCiphertext("old", "token") + "\n"
+	envFile := writeTempFile(t, ".env", envContent)
+	defer os.RemoveAll(filepath.Dir(envFile))
+	configContent := `{"KMS_TOKEN": "` + fakeCiphertext("old", "token") + `"`
+	configFile := writeTempFile(t, "config.json", configContent)
+	defer os.RemoveAll(filepath.Dir(configFile))
+
+	require.NoError(t, r.ReEncryptEnvFile(envFile))
+	assert.Error(t, r.ReEncryptConfigFile(configFile))
+	assert.Equal(t, envContent, readFile(t, envFile))
+}
+
+func TestReEncryptApplyReturnsWrittenChanges(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	first := writeTempFile(t, ".env", "KMS_TOKEN="+fakeCiphertext("old", "token")+"\n")
+	defer os.RemoveAll(filepath.Dir(first))
+	second := writeTempFile(t, ".env", "KMS_TOKEN="+fakeCiphertext("old", "token")+"\n")
+	require.NoError(t, r.ReEncryptEnvFile(first))
+	require.NoError(t, r.ReEncryptEnvFile(second))
+	require.NoError(t, os.RemoveAll(filepath.Dir(second)))
+
+	applied, err := r.Apply()
+	assert.Error(t, err)
+	assert.Equal(t, r.Changes[:1], applied)
+	assert.Contains(t, readFile(t, first), r.Changes[0].New)
+}
+
+func TestReEncryptSSMParameter(t *testing.T) {
+	r, server := newReEncrypter(t)
+	defer server.Close()
+
+	fake := &fakeSSM{Value: "kms://" + fakeCiphertext("old", "key")}
+	ssmServer := httptest.NewServer(fake)
+	defer ssmServer.Close()
+	ssmClient := ssm.New(newSession(t, ssmServer.URL))
+
+	require.NoError(t, r.ReEncryptSSMParameter(ssmClient, "/app/key"))
+	assert.Nil(t, fake.Put)
+	_, err := r.Apply()
+	require.NoError(t, err)
+
+	require.Len(t, r.Changes, 1)
+	assert.Equal(t, map[string]interface{}{
+		"Name":           "/app/key",
+		"Type":           "SecureString",
+		"Value":          r.Changes[0].New,
+		"Overwrite":      true,
+		"KeyId":          "alias/app",
+		"Tier":           "Advanced",
+		"AllowedPattern": "^kms://.*",
+		"Description":    "Private key",
+		"DataType":       "text",
+		"Policies":       `[{"Type":"ExpirationNotification"}]`,
+	}, fake.Put)
+	assert.Equal(t, fakeKMSBlob{KeyID: "target", Plaintext: "key"}, fakeCiphertextBlob(t, strings.TrimPrefix(r.Changes[0].New, "kms://")))
+}
@@ -56,13 +56,21 @@ func (e *Environment) Validate() error {
 	return nil
 }
 
-func LoadEnvironment(sessionFlags *common.SessionFlags, configFilenameTemplate, environmentName string) (*Environment, error) {
-
+func LoadConfigValues(configFilenameTemplate, environmentName string) (*common.ConfigValues, error) {
 	c := common.NewConfigValues()
 	err := c.SetFromJSON(fmt.Sprintf(configFilenameTemplate, environmentName))
 	if err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+func LoadEnvironment(sessionFlags *common.SessionFlags, configFilenameTemplate, environmentName string) (*Environment, error) {
+
+	c, err := LoadConfigValues(configFilenameTemplate, environmentName)
+	if err != nil {
+		return nil, err
+	}
 
 	session, config := common.OpenSession(sessionFlags)
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/hamstah/awstools/common"
@@ -14,22 +15,33 @@ var (
 	configFilenameTemplate = kingpin.Flag("config-filename-template", "Filename of the configuration file.").Default("%s.json").String()
 	eventFilename          = kingpin.Flag("event-filename", "Filename with the event payload. Will process the event and exit if present.").String()
 	identityURLMaxAge      = kingpin.Flag("identity-url-max-age", "Maximum age of the identity URL signature.").Default("10s").Duration()
+	validate               = kingpin.Flag("validate", "Check the sources of the environment config of the event can be accessed without fetching them, requires --event-filename.").Bool()
+	explain                = kingpin.Flag("explain", "List the values of the environment config of the event and whether they resolve, requires --event-filename.").Bool()
 )
 
 func main() {
 	kingpin.CommandLine.Name = "lambda-sign-ssh-key"
 	kingpin.CommandLine.Help = "Signs SSH keys."
 	sessionFlags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	handler := Handler(sessionFlags, *configFilenameTemplate, *identityURLMaxAge)
 
 	if *eventFilename == "" {
+		if *validate || *explain {
+			common.Fatalln("--validate and --explain require --event-filename")
+		}
 		lambda.Start(handler)
 	} else {
 		event := SignSSHKeyEvent{}
 		err := common.LoadJSON(*eventFilename, &event)
 		common.FatalOnError(err)
 
+		if *validate || *explain {
+			checkEnvironment(sessionFlags, event)
+			return
+		}
+
 		response, err := handler(context.Background(), event)
 		common.FatalOnError(err)
 
@@ -39,3 +51,21 @@ func main() {
 		fmt.Println(string(bytes))
 	}
 }
+
+func checkEnvironment(sessionFlags *common.SessionFlags, event SignSSHKeyEvent) {
+	c, err := LoadConfigValues(*configFilenameTemplate, event.Environment)
+	common.FatalOnError(err)
+
+	session, config := common.OpenSession(sessionFlags)
+
+	if *validate {
+		common.FatalOnError(c.Validate(session, config))
+	}
+
+	if *explain {
+		failed := common.WriteExplain(os.Stdout, c.Explain(session, config))
+		if failed > 0 {
+			common.Fatalln(fmt.Sprintf("%d values failed to resolve", failed))
+		}
+	}
+}
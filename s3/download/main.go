@@ -19,6 +19,7 @@ func main() {
 	kingpin.CommandLine.Name = "s3-download"
 	kingpin.CommandLine.Help = "Download a file from S3."
 	flags := common.HandleFlags()
+	defer common.RunExitHandlers()
 
 	session, conf := common.OpenSession(flags)
 